@@ -1,19 +1,18 @@
 package main
 
 import (
-	"bytes"
 	"context"
-	"encoding/json"
 	"flag"
 	"fmt"
-	"math/rand"
-	"net/http"
+	"log"
 	"os"
-	"runtime"
+	"os/signal"
 	"strconv"
-	"time"
+	"strings"
+	"syscall"
 
-	"github.com/JohnRobertFord/go-plant/internal/utils"
+	"github.com/JohnRobertFord/go-plant/pkg/agent"
+	"github.com/JohnRobertFord/go-plant/pkg/collector"
 )
 
 var pollInterval = 2
@@ -21,130 +20,46 @@ var reportInterval = 10
 var pollInt *int
 var repInt *int
 var remote *string
-
-type Element struct {
-	ID    string   `json:"id"`
-	MType string   `json:"type"`
-	Delta *int64   `json:"delta,omitempty"`
-	Value *float64 `json:"value,omitempty"`
-}
-
-type Metrics struct {
-	memstats    *runtime.MemStats
-	PollCount   int64
-	RandomValue uint64
-}
-
-func FormatMetric(t string, name string, value uint64) Element {
-	val := float64(value)
-	return Element{
-		ID:    name,
-		MType: t,
-		Value: &val,
+var key *string
+var batchSize *int
+var rateLimit *int
+var collectors *string
+var disabledCollectors *string
+var spoolDir *string
+var spoolMaxSize *int64
+var spoolMaxAge *int
+var labels *string
+var tlsCA *string
+var tlsCert *string
+var tlsKey *string
+var cryptoKey *string
+var transport *string
+
+// parseLabels reads labels in form "host=a,service=b"
+func parseLabels(s string) (map[string]string, error) {
+	items := splitList(s)
+	if len(items) == 0 {
+		return nil, nil
 	}
-}
-
-func FormatFloatMetric(t string, name string, value float64) Element {
-	return Element{
-		ID:    name,
-		MType: t,
-		Value: &value,
-	}
-}
-
-func FormatCounter(t string, name string, value int64) Element {
-	return Element{
-		ID:    name,
-		MType: t,
-		Delta: &value,
-	}
-}
-
-func (m *Metrics) GetMetrics() []Element {
-	metrics := make([]Element, 29)
-	metrics[0] = FormatMetric("gauge", "Alloc", m.memstats.Alloc)
-	metrics[1] = FormatMetric("gauge", "BuckHashSys", m.memstats.BuckHashSys)
-	metrics[2] = FormatMetric("gauge", "Frees", m.memstats.Frees)
-	metrics[3] = FormatMetric("gauge", "GCSys", m.memstats.GCSys)
-	metrics[4] = FormatMetric("gauge", "HeapAlloc", m.memstats.HeapAlloc)
-	metrics[5] = FormatMetric("gauge", "HeapIdle", m.memstats.HeapIdle)
-	metrics[6] = FormatMetric("gauge", "HeapInuse", m.memstats.HeapInuse)
-	metrics[7] = FormatMetric("gauge", "HeapObjects", m.memstats.HeapObjects)
-	metrics[8] = FormatMetric("gauge", "HeapReleased", m.memstats.HeapReleased)
-	metrics[9] = FormatMetric("gauge", "HeapSys", m.memstats.HeapSys)
-	metrics[10] = FormatMetric("gauge", "LastGC", m.memstats.LastGC)
-	metrics[11] = FormatMetric("gauge", "Lookups", m.memstats.Lookups)
-	metrics[12] = FormatMetric("gauge", "MCacheInuse", m.memstats.MCacheInuse)
-	metrics[13] = FormatMetric("gauge", "MCacheSys", m.memstats.MCacheSys)
-	metrics[14] = FormatMetric("gauge", "MSpanInuse", m.memstats.MSpanInuse)
-	metrics[15] = FormatMetric("gauge", "MSpanSys", m.memstats.MSpanSys)
-	metrics[16] = FormatMetric("gauge", "Mallocs", m.memstats.Mallocs)
-	metrics[17] = FormatMetric("gauge", "NextGC", m.memstats.NextGC)
-	metrics[18] = FormatMetric("gauge", "NumForcedGC", uint64(m.memstats.NumForcedGC))
-	metrics[19] = FormatMetric("gauge", "NumGC", uint64(m.memstats.NumGC))
-	metrics[20] = FormatMetric("gauge", "OtherSys", m.memstats.OtherSys)
-	metrics[21] = FormatMetric("gauge", "PauseTotalNs", m.memstats.PauseTotalNs)
-	metrics[22] = FormatMetric("gauge", "StackInuse", m.memstats.StackInuse)
-	metrics[23] = FormatMetric("gauge", "StackSys", m.memstats.StackSys)
-	metrics[24] = FormatMetric("gauge", "Sys", m.memstats.Sys)
-	metrics[25] = FormatMetric("gauge", "TotalAlloc", m.memstats.TotalAlloc)
-	metrics[26] = FormatFloatMetric("gauge", "GCCPUFraction", m.memstats.GCCPUFraction)
-	metrics[27] = FormatCounter("counter", "PollCount", 1)
-	metrics[28] = FormatMetric("gauge", "RandomValue", rand.Uint64())
-
-	return metrics
-}
-
-func SendMetric(val string) {
-
-	var err error
-	ctx := context.Context(context.Background())
-	err = utils.Retry(ctx, func() error {
-		resp, err := http.Post(val, "text/plain", nil)
-		if err != nil {
-			return err
+	labels := make(map[string]string, len(items))
+	for _, item := range items {
+		k, v, ok := strings.Cut(item, "=")
+		if !ok || k == "" {
+			return nil, fmt.Errorf("bad label %q, want name=value", item)
 		}
-		defer resp.Body.Close()
-		return nil
-	})
-	if err != nil {
-		fmt.Println(err)
+		labels[strings.TrimSpace(k)] = strings.TrimSpace(v)
 	}
+	return labels, nil
 }
 
-func PrepareData(els []Element) {
-	var str string
-	for _, el := range els {
-		if el.MType == "gauge" {
-			str = fmt.Sprintf("http://%s/update/%s/%s/%v", *remote, el.MType, el.ID, *(el.Value))
-		} else {
-			str = fmt.Sprintf("http://%s/update/%s/%s/%v", *remote, el.MType, el.ID, *(el.Delta))
+func splitList(s string) []string {
+	var out []string
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			out = append(out, item)
 		}
-		SendMetric(str)
-	}
-}
-
-func SendJSONData(els []Element) {
-
-	var err error
-	ret, err := json.Marshal(els)
-	if err != nil {
-		fmt.Println(err)
-		return
-	}
-	r := bytes.NewReader(ret)
-	ctx := context.Context(context.Background())
-	err = utils.Retry(ctx, func() error {
-		resp, err := http.Post("http://"+*remote+"/update/", "application/json", r)
-		if err != nil {
-			return err
-		}
-		defer resp.Body.Close()
-		return err
-	})
-	if err != nil {
-		fmt.Println(err)
 	}
+	return out
 }
 
 func main() {
@@ -152,15 +67,83 @@ func main() {
 	remote = flag.String("a", "127.0.0.1:8080", "remote endpoint")
 	repInt = flag.Int("r", reportInterval, "report interval")
 	pollInt = flag.Int("p", pollInterval, "poll interval")
+	key = flag.String("k", "", "key for HMAC-SHA256 signing")
+	batchSize = flag.Int("b", 100, "max metrics per batch, 0 sends all at once")
+	rateLimit = flag.Int("l", 1, "max concurrent outgoing requests")
+	collectors = flag.String("c", "", "comma separated collectors to enable, all if empty: "+strings.Join(collector.Names(), ","))
+	disabledCollectors = flag.String("x", "", "comma separated collectors to disable")
+	spoolDir = flag.String("s", "", "directory to keep unsent batches in, disabled if empty")
+	spoolMaxSize = flag.Int64("spool-max-size", 10<<20, "max total size of unsent batches in bytes, 0 is unlimited")
+	spoolMaxAge = flag.Int("spool-max-age", 3600, "max age of unsent batches in seconds, 0 is unlimited")
+	labels = flag.String("labels", "", "labels added to every metric, e.g. host=a,service=b")
+	tlsCA = flag.String("tls-ca", "", "CA to verify server certificate with, enables HTTPS")
+	tlsCert = flag.String("tls-cert", "", "client certificate for mTLS, enables HTTPS")
+	tlsKey = flag.String("tls-key", "", "client certificate key for mTLS")
+	transport = flag.String("transport", "http", "protocol to send metrics with: http, grpc or grpc-stream")
+	cryptoKey = flag.String("crypto-key", "", "server RSA public key to encrypt request bodies with")
 
 	ri := os.Getenv("REPORT_INTERVAL")
 	pi := os.Getenv("POLL_INTERVAL")
+	bs := os.Getenv("BATCH_SIZE")
+	rl := os.Getenv("RATE_LIMIT")
+	sms := os.Getenv("SPOOL_MAX_SIZE")
+	sma := os.Getenv("SPOOL_MAX_AGE")
 
 	flag.Parse()
 
 	if os.Getenv("ADDRESS") != "" {
 		*remote = os.Getenv("ADDRESS")
 	}
+	if os.Getenv("KEY") != "" {
+		*key = os.Getenv("KEY")
+	}
+	if bs != "" {
+		if b, err := strconv.Atoi(bs); err == nil {
+			*batchSize = b
+		}
+	}
+	if rl != "" {
+		if l, err := strconv.Atoi(rl); err == nil {
+			*rateLimit = l
+		}
+	}
+	if os.Getenv("COLLECTORS") != "" {
+		*collectors = os.Getenv("COLLECTORS")
+	}
+	if os.Getenv("DISABLED_COLLECTORS") != "" {
+		*disabledCollectors = os.Getenv("DISABLED_COLLECTORS")
+	}
+	if os.Getenv("LABELS") != "" {
+		*labels = os.Getenv("LABELS")
+	}
+	if os.Getenv("TLS_CA") != "" {
+		*tlsCA = os.Getenv("TLS_CA")
+	}
+	if os.Getenv("TLS_CERT") != "" {
+		*tlsCert = os.Getenv("TLS_CERT")
+	}
+	if os.Getenv("TLS_KEY") != "" {
+		*tlsKey = os.Getenv("TLS_KEY")
+	}
+	if os.Getenv("TRANSPORT") != "" {
+		*transport = os.Getenv("TRANSPORT")
+	}
+	if os.Getenv("CRYPTO_KEY") != "" {
+		*cryptoKey = os.Getenv("CRYPTO_KEY")
+	}
+	if os.Getenv("SPOOL_DIR") != "" {
+		*spoolDir = os.Getenv("SPOOL_DIR")
+	}
+	if sms != "" {
+		if v, err := strconv.ParseInt(sms, 10, 64); err == nil {
+			*spoolMaxSize = v
+		}
+	}
+	if sma != "" {
+		if v, err := strconv.Atoi(sma); err == nil {
+			*spoolMaxAge = v
+		}
+	}
 
 	var rInt int
 	if ri == "" {
@@ -184,25 +167,38 @@ func main() {
 		}
 	}
 
-	myM := Metrics{
-		memstats: &runtime.MemStats{},
+	staticLabels, err := parseLabels(*labels)
+	if err != nil {
+		log.Fatal(err)
 	}
 
-	runtime.ReadMemStats(myM.memstats)
-	PrepareData(myM.GetMetrics())
-	SendJSONData(myM.GetMetrics())
-
-	if pInt <= rInt {
-		c := (rInt / pInt)
-		delta := rInt - (c * pInt)
-		for {
-			for i := 0; i < c; i++ {
-				time.Sleep(time.Duration(*pollInt) * time.Second)
-				runtime.ReadMemStats(myM.memstats)
-			}
-			time.Sleep(time.Duration(delta) * time.Second)
-			PrepareData(myM.GetMetrics())
-			SendJSONData(myM.GetMetrics())
-		}
+	ctx, stop := signal.NotifyContext(context.Background(),
+		syscall.SIGINT,
+		syscall.SIGTERM,
+		syscall.SIGQUIT,
+	)
+	defer stop()
+
+	err = agent.Run(ctx, agent.Config{
+		Address:            *remote,
+		Key:                *key,
+		PollInterval:       pInt,
+		ReportInterval:     rInt,
+		BatchSize:          *batchSize,
+		RateLimit:          *rateLimit,
+		Collectors:         splitList(*collectors),
+		DisabledCollectors: splitList(*disabledCollectors),
+		SpoolDir:           *spoolDir,
+		SpoolMaxSize:       *spoolMaxSize,
+		SpoolMaxAge:        *spoolMaxAge,
+		Labels:             staticLabels,
+		TLSCA:              *tlsCA,
+		TLSCert:            *tlsCert,
+		TLSKey:             *tlsKey,
+		Transport:          *transport,
+		CryptoKey:          *cryptoKey,
+	})
+	if err != nil {
+		log.Fatal(err)
 	}
 }
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseLabels(t *testing.T) {
+	var tests = []struct {
+		name    string
+		in      string
+		want    map[string]string
+		wantErr bool
+	}{
+		{name: "empty", in: ""},
+		{name: "single", in: "host=a", want: map[string]string{"host": "a"}},
+		{name: "spaces and empty items", in: " host = a ,, service=api ", want: map[string]string{"host": "a", "service": "api"}},
+		{name: "empty value", in: "host=", want: map[string]string{"host": ""}},
+		{name: "no value", in: "host", wantErr: true},
+		{name: "no name", in: "=a", wantErr: true},
+		{name: "one bad of many", in: "host=a,service", wantErr: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := parseLabels(test.in)
+			if test.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, test.want, got)
+		})
+	}
+}
@@ -3,12 +3,16 @@ package main
 import (
 	"context"
 	"log"
+	"net"
 	"os"
 	"os/signal"
+	"strings"
 	"syscall"
 	"time"
 
+	"github.com/JohnRobertFord/go-plant/internal/alert"
 	"github.com/JohnRobertFord/go-plant/internal/config"
+	"github.com/JohnRobertFord/go-plant/internal/grpcserver"
 	"github.com/JohnRobertFord/go-plant/internal/server"
 	"github.com/JohnRobertFord/go-plant/internal/storage/metrics"
 	"github.com/JohnRobertFord/go-plant/internal/storage/metrics/cache"
@@ -16,6 +20,11 @@ import (
 	"github.com/JohnRobertFord/go-plant/internal/storage/metrics/postgres"
 )
 
+const (
+	shutdownTimeout = 10 * time.Second
+	compactInterval = 30 * time.Second
+)
+
 func main() {
 
 	cfg, err := config.InitConfig()
@@ -26,10 +35,23 @@ func main() {
 	var storage metrics.Storage
 	ctx := context.Background()
 
+	if cfg.MigrateOnly {
+		if cfg.DatabaseDsn == "" {
+			log.Fatal("-migrate-only needs database dsn")
+		}
+		pg, err := postgres.NewPostgresStorage(cfg)
+		if err != nil {
+			log.Fatalf("[ERR][MIGRATE] %s", err)
+		}
+		pg.Close()
+		log.Println("[MIGRATE] schema is up to date")
+		return
+	}
+
 	if cfg.DatabaseDsn != "" {
 		storage, err = postgres.NewPostgresStorage(cfg)
 		if err != nil {
-			log.Fatal(err)
+			log.Fatalf("[ERR][DB] can't start with database: %s", err)
 		}
 	} else {
 		storage = cache.NewMemStorage(cfg)
@@ -42,32 +64,149 @@ func main() {
 		}
 	}
 
+	storeCtx, stopStore := context.WithCancel(ctx)
+	storeDone := make(chan struct{})
 	if cfg.StoreInterval > 0 && cfg.DatabaseDsn == "" {
 		sleep := time.Duration(cfg.StoreInterval) * time.Second
 		go func(ms metrics.Storage, t time.Duration) {
+			defer close(storeDone)
 			for {
-				<-time.After(t)
+				select {
+				case <-storeCtx.Done():
+					return
+				case <-time.After(t):
+				}
 				err := diskfile.Write2File(ctx, ms)
 				if err != nil {
 					log.Printf("[ERR][FILE] cant write to file: %e", err)
 				}
 			}
 		}(storage, sleep)
+	} else {
+		close(storeDone)
+	}
+
+	compactDone := make(chan struct{})
+	if cfg.History {
+		go func() {
+			defer close(compactDone)
+			for {
+				select {
+				case <-storeCtx.Done():
+					return
+				case <-time.After(compactInterval):
+				}
+				if err := storage.Compact(storeCtx, time.Now()); err != nil {
+					log.Printf("[ERR][ROLLUP] cant compact history: %s", err)
+				}
+			}
+		}()
+	} else {
+		close(compactDone)
 	}
 
-	metricServer := server.NewMetricServer(cfg, storage)
+	var alerts *alert.Engine
+	alertDone := make(chan struct{})
+	if cfg.AlertRules != "" {
+		rules, err := alert.LoadRules(cfg.AlertRules)
+		if err != nil {
+			log.Fatalf("can't load alert rules: %s", err)
+		}
+		alerts = alert.NewEngine(storage, rules)
+		log.Printf("[ALERT] loaded %d rules from %s", len(rules), cfg.AlertRules)
+
+		notifyDone := make(chan struct{})
+		if cfg.AlertWebhooks != "" {
+			notifier := alert.NewNotifier(strings.Split(cfg.AlertWebhooks, ","), time.Duration(cfg.AlertGroup)*time.Second)
+			alerts.SetNotifier(notifier)
+			go func() {
+				defer close(notifyDone)
+				notifier.Run(storeCtx)
+			}()
+		} else {
+			close(notifyDone)
+		}
+
+		go func() {
+			defer close(alertDone)
+			defer func() { <-notifyDone }()
+			for {
+				select {
+				case <-storeCtx.Done():
+					return
+				case <-time.After(time.Duration(cfg.AlertInterval) * time.Second):
+				}
+				alerts.Evaluate(storeCtx, time.Now())
+			}
+		}()
+	} else {
+		close(alertDone)
+	}
+
+	metricServer := server.NewMetricServer(cfg, storage, alerts)
 
 	go metricServer.RunServer()
 
+	var grpcServer *grpcserver.Server
+	if cfg.GRPCBind != "" {
+		grpcServer, err = grpcserver.New(cfg, storage)
+		if err != nil {
+			log.Fatalf("can't init grpc server: %s", err)
+		}
+		lis, err := net.Listen("tcp", cfg.GRPCBind)
+		if err != nil {
+			log.Fatalf("can't listen grpc: %s", err)
+		}
+		go func() {
+			if err := grpcServer.Serve(lis); err != nil {
+				log.Fatal(err)
+			}
+		}()
+	}
+
 	sigChan := make(chan os.Signal, 1)
 	signal.Notify(sigChan,
 		syscall.SIGINT,
 		syscall.SIGTERM,
 		syscall.SIGQUIT,
 	)
-	<-sigChan
+	sig := <-sigChan
+	log.Printf("[SHUTDOWN] got %s, stopping server", sig)
+
+	shutdownCtx, cancel := context.WithTimeout(ctx, shutdownTimeout)
+	defer cancel()
+	if err := metricServer.Shutdown(shutdownCtx); err != nil {
+		log.Printf("[ERR][SHUTDOWN] cant drain requests: %s", err)
+	} else {
+		log.Println("[SHUTDOWN] in-flight requests finished")
+	}
+	if grpcServer != nil {
+		stopped := make(chan struct{})
+		go func() {
+			grpcServer.GracefulStop()
+			close(stopped)
+		}()
+		select {
+		case <-stopped:
+			log.Println("[SHUTDOWN] grpc calls finished")
+		case <-shutdownCtx.Done():
+			grpcServer.Stop()
+			log.Println("[ERR][SHUTDOWN] grpc calls cancelled")
+		}
+	}
+
+	stopStore()
+	<-storeDone
+	<-compactDone
+	<-alertDone
+
 	err = diskfile.Write2File(ctx, storage)
 	if err != nil {
 		log.Printf("[ERR][FILE] cant write to file: %e", err)
+	} else {
+		log.Printf("[SHUTDOWN] metrics saved to %s", cfg.FilePath)
 	}
+
+	storage.Close()
+	log.Println("[SHUTDOWN] storage closed")
 }
@@ -37,7 +37,7 @@ func TestMetricRouter(t *testing.T) {
 
 	storage := cache.NewMemStorage(cfg)
 
-	metricServer := server.NewMetricServer(cfg, storage)
+	metricServer := server.NewMetricServer(cfg, storage, nil)
 
 	ts := httptest.NewServer(metricServer.Server.Handler)
 	defer ts.Close()
@@ -91,6 +91,13 @@ func TestMetricRouter(t *testing.T) {
 			want:   "",
 			status: http.StatusOK,
 		},
+		{
+			name:   "Prometheus test",
+			url:    "/metrics",
+			method: "GET",
+			want:   "# TYPE Alloc gauge\nAlloc 188893\n# TYPE counter counter\ncounter 1\n",
+			status: http.StatusOK,
+		},
 	}
 
 	for _, test := range tests {
@@ -104,3 +111,70 @@ func TestMetricRouter(t *testing.T) {
 
 	}
 }
+
+func TestHistoryRouter(t *testing.T) {
+	cfg := &config.Config{History: true}
+	ts := httptest.NewServer(server.NewMetricServer(cfg, cache.NewMemStorage(cfg), nil).Server.Handler)
+	defer ts.Close()
+
+	resp, _ := testRequest(t, ts, "POST", "/update/gauge/Load/1")
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	var tests = []struct {
+		name   string
+		url    string
+		want   string
+		status int
+	}{
+		{name: "all samples", url: "/history/gauge/Load", status: http.StatusOK},
+		{name: "unix from", url: "/history/gauge/Load?from=0&to=2000000000", status: http.StatusOK},
+		{name: "rfc3339 to", url: "/history/gauge/Load?to=2030-01-01T00:00:00Z", status: http.StatusOK},
+		{name: "unknown series", url: "/history/gauge/Unknown", want: "[]\n", status: http.StatusOK},
+		{name: "bad from", url: "/history/gauge/Load?from=yesterday", status: http.StatusBadRequest},
+		{name: "bad to", url: "/history/gauge/Load?to=2030-13-01", status: http.StatusBadRequest},
+		{name: "bad step", url: "/history/gauge/Load?step=5m", status: http.StatusBadRequest},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			resp, body := testRequest(t, ts, "GET", test.url)
+			assert.Equal(t, test.status, resp.StatusCode)
+			if test.want != "" {
+				assert.Equal(t, test.want, body)
+			}
+		})
+	}
+}
+
+func TestLabelsRouter(t *testing.T) {
+	cfg := &config.Config{}
+	ts := httptest.NewServer(server.NewMetricServer(cfg, cache.NewMemStorage(cfg), nil).Server.Handler)
+	defer ts.Close()
+
+	for _, url := range []string{"/update/gauge/Load/1?host=a", "/update/gauge/Load/2?host=b", "/update/gauge/Load/3"} {
+		resp, _ := testRequest(t, ts, "POST", url)
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+	}
+
+	var tests = []struct {
+		name   string
+		url    string
+		want   string
+		status int
+	}{
+		{name: "host a", url: "/value/gauge/Load?host=a", want: "1\n", status: http.StatusOK},
+		{name: "host b", url: "/value/gauge/Load?host=b", want: "2\n", status: http.StatusOK},
+		{name: "no labels", url: "/value/gauge/Load", want: "3\n", status: http.StatusOK},
+		{name: "unknown host", url: "/value/gauge/Load?host=c", status: http.StatusNotFound},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			resp, body := testRequest(t, ts, "GET", test.url)
+			assert.Equal(t, test.status, resp.StatusCode)
+			if test.want != "" {
+				assert.Equal(t, test.want, body)
+			}
+		})
+	}
+}
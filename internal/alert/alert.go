@@ -0,0 +1,214 @@
+package alert
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/JohnRobertFord/go-plant/internal/storage/metrics"
+)
+
+type State string
+
+const (
+	Inactive State = "inactive"
+	Pending  State = "pending"
+	Firing   State = "firing"
+	Resolved State = "resolved"
+)
+
+// Duration reads durations like "30s" or "5m" from json
+type Duration struct {
+	time.Duration
+}
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(d.String())
+}
+
+func (d *Duration) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return err
+	}
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	d.Duration = v
+	return nil
+}
+
+// Rule fires when metric value compared by Op with Threshold holds for the For duration
+type Rule struct {
+	Name      string            `json:"name"`
+	ID        string            `json:"id"`
+	MType     string            `json:"type"`
+	Labels    map[string]string `json:"labels,omitempty"`
+	Op        string            `json:"op"`
+	Threshold float64           `json:"threshold"`
+	For       Duration          `json:"for"`
+}
+
+func (r Rule) match(v float64) bool {
+	switch r.Op {
+	case ">":
+		return v > r.Threshold
+	case ">=":
+		return v >= r.Threshold
+	case "<":
+		return v < r.Threshold
+	case "<=":
+		return v <= r.Threshold
+	case "==":
+		return v == r.Threshold
+	case "!=":
+		return v != r.Threshold
+	}
+	return false
+}
+
+func (r Rule) validate() error {
+	if r.Name == "" || r.ID == "" {
+		return fmt.Errorf("rule needs name and id: %+v", r)
+	}
+	if r.MType != "gauge" && r.MType != "counter" {
+		return fmt.Errorf("rule %q: unknown type %q", r.Name, r.MType)
+	}
+	switch r.Op {
+	case ">", ">=", "<", "<=", "==", "!=":
+	default:
+		return fmt.Errorf("rule %q: unknown op %q", r.Name, r.Op)
+	}
+	return nil
+}
+
+type Alert struct {
+	Rule       Rule       `json:"rule"`
+	State      State      `json:"state"`
+	Value      *float64   `json:"value,omitempty"`
+	ActiveAt   *time.Time `json:"activeAt,omitempty"`
+	FiredAt    *time.Time `json:"firedAt,omitempty"`
+	ResolvedAt *time.Time `json:"resolvedAt,omitempty"`
+}
+
+// LoadRules reads json array of rules from file
+func LoadRules(path string) ([]Rule, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var rules []Rule
+	if err = json.Unmarshal(data, &rules); err != nil {
+		return nil, fmt.Errorf("cant parse rules %s: %w", path, err)
+	}
+	names := make(map[string]bool)
+	for _, r := range rules {
+		if err = r.validate(); err != nil {
+			return nil, err
+		}
+		if names[r.Name] {
+			return nil, fmt.Errorf("duplicate rule %q", r.Name)
+		}
+		names[r.Name] = true
+	}
+	return rules, nil
+}
+
+type Engine struct {
+	ms       metrics.Storage
+	mu       sync.Mutex
+	alerts   []*Alert
+	notifier *Notifier
+}
+
+func NewEngine(ms metrics.Storage, rules []Rule) *Engine {
+	e := &Engine{ms: ms}
+	for _, r := range rules {
+		e.alerts = append(e.alerts, &Alert{Rule: r, State: Inactive})
+	}
+	return e
+}
+
+// SetNotifier makes engine report alerts that start firing or get resolved
+func (e *Engine) SetNotifier(n *Notifier) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	e.notifier = n
+}
+
+// Evaluate checks every rule against current values in storage. Values are read
+// without holding mu, so that storage latency does not block Alerts
+func (e *Engine) Evaluate(ctx context.Context, now time.Time) {
+	// alerts and their rules never change after NewEngine, only state is guarded by mu
+	values := make([]*float64, len(e.alerts))
+	for i, a := range e.alerts {
+		el, err := e.ms.Select(ctx, metrics.Element{ID: a.Rule.ID, MType: a.Rule.MType, Labels: a.Rule.Labels})
+		if err != nil {
+			continue
+		}
+		switch {
+		case el.Value != nil:
+			v := *el.Value
+			values[i] = &v
+		case el.Delta != nil:
+			v := float64(*el.Delta)
+			values[i] = &v
+		}
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	for i, a := range e.alerts {
+		value := values[i]
+		a.Value = value
+		prev := a.State
+		a.step(value != nil && a.Rule.match(*value), now)
+		if e.notifier != nil && a.State != prev && (a.State == Firing || a.State == Resolved) {
+			e.notifier.Notify(*a)
+		}
+	}
+}
+
+// step moves alert through inactive -> pending -> firing -> resolved
+func (a *Alert) step(active bool, now time.Time) {
+	if active {
+		if a.State == Inactive || a.State == Resolved {
+			a.State = Pending
+			a.ActiveAt = &now
+			a.FiredAt, a.ResolvedAt = nil, nil
+		}
+		if a.State == Pending && now.Sub(*a.ActiveAt) >= a.Rule.For.Duration {
+			a.State = Firing
+			a.FiredAt = &now
+		}
+		return
+	}
+	switch a.State {
+	case Pending:
+		a.State = Inactive
+		a.ActiveAt = nil
+	case Firing:
+		a.State = Resolved
+		a.ResolvedAt = &now
+	}
+}
+
+// Alerts returns copies of all alerts not in inactive state
+func (e *Engine) Alerts() []Alert {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	out := []Alert{}
+	for _, a := range e.alerts {
+		if a.State != Inactive {
+			out = append(out, *a)
+		}
+	}
+	return out
+}
@@ -0,0 +1,56 @@
+package alert
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/JohnRobertFord/go-plant/internal/config"
+	"github.com/JohnRobertFord/go-plant/internal/storage/metrics"
+	"github.com/JohnRobertFord/go-plant/internal/storage/metrics/cache"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEvaluate(t *testing.T) {
+	ctx := context.Background()
+	ms := cache.NewMemStorage(&config.Config{})
+	set := func(v float64) {
+		_, err := ms.Insert(ctx, metrics.Element{ID: "Load", MType: "gauge", Value: &v})
+		require.NoError(t, err)
+	}
+
+	e := NewEngine(ms, []Rule{{
+		Name:      "high load",
+		ID:        "Load",
+		MType:     "gauge",
+		Op:        ">",
+		Threshold: 10,
+		For:       Duration{time.Minute},
+	}})
+	now := time.Now()
+
+	var tests = []struct {
+		name  string
+		value float64
+		after time.Duration
+		want  State
+	}{
+		{name: "below threshold", value: 5, after: 0, want: Inactive},
+		{name: "above threshold", value: 20, after: 0, want: Pending},
+		{name: "not long enough", value: 20, after: 30 * time.Second, want: Pending},
+		{name: "held for duration", value: 20, after: 90 * time.Second, want: Firing},
+		{name: "back to normal", value: 5, after: 2 * time.Minute, want: Resolved},
+		{name: "again above", value: 20, after: 3 * time.Minute, want: Pending},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			set(test.value)
+			e.Evaluate(ctx, now.Add(test.after))
+			e.mu.Lock()
+			defer e.mu.Unlock()
+			assert.Equal(t, test.want, e.alerts[0].State)
+		})
+	}
+}
@@ -0,0 +1,192 @@
+package alert
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/JohnRobertFord/go-plant/internal/utils"
+)
+
+const deliveryLogSize = 100
+
+// Notification is the json body posted to webhooks
+type Notification struct {
+	Alerts []Alert `json:"alerts"`
+}
+
+// Delivery is a record of a single webhook call
+type Delivery struct {
+	Time   time.Time `json:"time"`
+	URL    string    `json:"url"`
+	Alerts int       `json:"alerts"`
+	Error  string    `json:"error,omitempty"`
+}
+
+// Notifier groups alert state changes for a window and posts them to webhooks.
+// A rule is notified again only when its state differs from the last one the webhook accepted.
+type Notifier struct {
+	urls   []string
+	group  time.Duration
+	client *http.Client
+
+	mu      sync.Mutex
+	pending map[string]Alert
+	order   []string
+	// last accepted state of a rule by webhook url
+	notified   map[string]map[string]State
+	deliveries []Delivery
+}
+
+func NewNotifier(urls []string, group time.Duration) *Notifier {
+	return &Notifier{
+		urls:     urls,
+		group:    group,
+		client:   &http.Client{Timeout: 10 * time.Second},
+		pending:  make(map[string]Alert),
+		notified: make(map[string]map[string]State),
+	}
+}
+
+// Notify queues alert until the next flush, the latest state of a rule wins
+func (n *Notifier) Notify(a Alert) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	name := a.Rule.Name
+	if _, ok := n.pending[name]; !ok {
+		n.order = append(n.order, name)
+	}
+	n.pending[name] = a
+}
+
+// Flush posts queued alerts to every webhook, each gets only states it has not accepted yet.
+// Alerts some webhook did not accept are queued again for the next flush, unless a newer
+// state of the rule is already waiting
+func (n *Notifier) Flush(ctx context.Context) {
+	n.mu.Lock()
+	alerts := make([]Alert, 0, len(n.order))
+	for _, name := range n.order {
+		alerts = append(alerts, n.pending[name])
+	}
+	n.pending = make(map[string]Alert)
+	n.order = nil
+	n.mu.Unlock()
+
+	failed := make(map[string]bool)
+	for _, url := range n.urls {
+		n.mu.Lock()
+		var send []Alert
+		for _, a := range alerts {
+			if n.notified[url][a.Rule.Name] != a.State {
+				send = append(send, a)
+			}
+		}
+		n.mu.Unlock()
+		if len(send) == 0 {
+			continue
+		}
+
+		body, err := json.Marshal(Notification{Alerts: send})
+		if err != nil {
+			log.Printf("[ERR][ALERT] cant marshal notification: %s", err)
+			return
+		}
+		err = utils.Retry(ctx, func() error {
+			return n.post(ctx, url, body)
+		})
+		n.record(url, len(send), err)
+
+		n.mu.Lock()
+		for _, a := range send {
+			if err != nil {
+				failed[a.Rule.Name] = true
+				continue
+			}
+			if n.notified[url] == nil {
+				n.notified[url] = make(map[string]State)
+			}
+			n.notified[url][a.Rule.Name] = a.State
+		}
+		n.mu.Unlock()
+	}
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	for _, a := range alerts {
+		name := a.Rule.Name
+		if !failed[name] {
+			continue
+		}
+		if _, ok := n.pending[name]; !ok {
+			n.order = append(n.order, name)
+			n.pending[name] = a
+		}
+	}
+}
+
+func (n *Notifier) post(ctx context.Context, url string, body []byte) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := n.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("unexpected status: %s", resp.Status)
+	}
+	return nil
+}
+
+func (n *Notifier) record(url string, alerts int, err error) {
+	d := Delivery{
+		Time:   time.Now(),
+		URL:    url,
+		Alerts: alerts,
+	}
+	if err != nil {
+		d.Error = err.Error()
+		log.Printf("[ERR][ALERT] notification to %s failed: %s", url, err)
+	} else {
+		log.Printf("[ALERT] sent %d alerts to %s", alerts, url)
+	}
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.deliveries = append(n.deliveries, d)
+	if len(n.deliveries) > deliveryLogSize {
+		n.deliveries = n.deliveries[len(n.deliveries)-deliveryLogSize:]
+	}
+}
+
+// Deliveries returns the most recent webhook calls, oldest first
+func (n *Notifier) Deliveries() []Delivery {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	return append([]Delivery(nil), n.deliveries...)
+}
+
+// Run flushes queued alerts every group window until ctx is done
+func (n *Notifier) Run(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			flushCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			n.Flush(flushCtx)
+			cancel()
+			return
+		case <-time.After(n.group):
+		}
+		n.Flush(ctx)
+	}
+}
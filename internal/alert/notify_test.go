@@ -0,0 +1,136 @@
+package alert
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/JohnRobertFord/go-plant/internal/config"
+	"github.com/JohnRobertFord/go-plant/internal/storage/metrics"
+	"github.com/JohnRobertFord/go-plant/internal/storage/metrics/cache"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNotifier(t *testing.T) {
+	var mu sync.Mutex
+	var received []Notification
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		var n Notification
+		if err := json.NewDecoder(req.Body).Decode(&n); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		mu.Lock()
+		received = append(received, n)
+		mu.Unlock()
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer ts.Close()
+
+	ctx := context.Background()
+	ms := cache.NewMemStorage(&config.Config{})
+	set := func(v float64) {
+		_, err := ms.Insert(ctx, metrics.Element{ID: "Load", MType: "gauge", Value: &v})
+		require.NoError(t, err)
+	}
+
+	n := NewNotifier([]string{ts.URL}, time.Second)
+	e := NewEngine(ms, []Rule{{Name: "high load", ID: "Load", MType: "gauge", Op: ">", Threshold: 10}})
+	e.SetNotifier(n)
+	now := time.Now()
+
+	set(20)
+	e.Evaluate(ctx, now)
+	e.Evaluate(ctx, now.Add(time.Second))
+	n.Flush(ctx)
+
+	// still firing, nothing new to send
+	e.Evaluate(ctx, now.Add(2*time.Second))
+	n.Flush(ctx)
+
+	// resolved and firing again inside one window is the same state as already sent
+	set(5)
+	e.Evaluate(ctx, now.Add(3*time.Second))
+	set(20)
+	e.Evaluate(ctx, now.Add(4*time.Second))
+	e.Evaluate(ctx, now.Add(5*time.Second))
+	n.Flush(ctx)
+
+	set(5)
+	e.Evaluate(ctx, now.Add(6*time.Second))
+	n.Flush(ctx)
+
+	mu.Lock()
+	defer mu.Unlock()
+	require.Len(t, received, 2)
+	assert.Equal(t, Firing, received[0].Alerts[0].State)
+	assert.Equal(t, Resolved, received[1].Alerts[0].State)
+
+	deliveries := n.Deliveries()
+	require.Len(t, deliveries, 2)
+	assert.Empty(t, deliveries[0].Error)
+	assert.Equal(t, ts.URL, deliveries[0].URL)
+}
+
+func TestNotifierRetry(t *testing.T) {
+	var mu sync.Mutex
+	// receiver records notifications, it answers with 500 while down is set
+	receiver := func(down *bool) (*httptest.Server, *[]Notification) {
+		var received []Notification
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			mu.Lock()
+			defer mu.Unlock()
+			if *down {
+				w.WriteHeader(http.StatusInternalServerError)
+				return
+			}
+			var n Notification
+			if err := json.NewDecoder(req.Body).Decode(&n); err != nil {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			received = append(received, n)
+			w.WriteHeader(http.StatusOK)
+		}))
+		t.Cleanup(ts.Close)
+		return ts, &received
+	}
+	up, down := false, true
+	healthy, healthyGot := receiver(&up)
+	flaky, flakyGot := receiver(&down)
+
+	n := NewNotifier([]string{healthy.URL, flaky.URL}, time.Second)
+	n.Notify(Alert{Rule: Rule{Name: "high load"}, State: Firing})
+
+	// retries inside one flush are cut short, the alert waits for the next one
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	n.Flush(ctx)
+	cancel()
+
+	mu.Lock()
+	down = false
+	mu.Unlock()
+	n.Flush(context.Background())
+	// delivered to both, nothing left to send
+	n.Flush(context.Background())
+
+	mu.Lock()
+	defer mu.Unlock()
+	// webhook that accepted the first time does not get it again
+	require.Len(t, *healthyGot, 1)
+	require.Len(t, *flakyGot, 1)
+	assert.Equal(t, Firing, (*flakyGot)[0].Alerts[0].State)
+
+	deliveries := n.Deliveries()
+	require.Len(t, deliveries, 3)
+	assert.Empty(t, deliveries[0].Error)
+	assert.Equal(t, flaky.URL, deliveries[1].URL)
+	assert.NotEmpty(t, deliveries[1].Error)
+	assert.Equal(t, flaky.URL, deliveries[2].URL)
+	assert.Empty(t, deliveries[2].Error)
+}
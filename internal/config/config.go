@@ -9,20 +9,42 @@ import (
 )
 
 type Config struct {
-	Bind          string `json:"bind" env:"ADDRESS"`
-	StoreInterval int    `json:"storeInterval" env:"STORE_INTERVAL"`
-	FilePath      string `json:"filePath" env:"FILE_STORAGE_PATH"`
-	Restore       bool   `json:"isRestored" env:"RESTORE"`
-	DatabaseDsn   string `json:"databaseDsn" env:"DATABASE_DSN"`
+	Bind             string `json:"bind" env:"ADDRESS"`
+	StoreInterval    int    `json:"storeInterval" env:"STORE_INTERVAL"`
+	FilePath         string `json:"filePath" env:"FILE_STORAGE_PATH"`
+	Restore          bool   `json:"isRestored" env:"RESTORE"`
+	DatabaseDsn      string `json:"databaseDsn" env:"DATABASE_DSN"`
+	Key              string `json:"-" env:"KEY"`
+	History          bool   `json:"history" env:"HISTORY"`
+	Retention        int    `json:"retention" env:"HISTORY_RETENTION"`
+	RollupRetention  int    `json:"rollupRetention" env:"ROLLUP_RETENTION"`
+	AlertRules       string `json:"alertRules" env:"ALERT_RULES"`
+	AlertInterval    int    `json:"alertInterval" env:"ALERT_INTERVAL"`
+	AlertWebhooks    string `json:"alertWebhooks" env:"ALERT_WEBHOOKS"`
+	AlertGroup       int    `json:"alertGroup" env:"ALERT_GROUP"`
+	MigrateOnly      bool   `json:"migrateOnly" env:"MIGRATE_ONLY"`
+	DBMaxConns       int    `json:"dbMaxConns" env:"DB_MAX_CONNS"`
+	DBMinConns       int    `json:"dbMinConns" env:"DB_MIN_CONNS"`
+	DBConnLifetime   int    `json:"dbConnLifetime" env:"DB_CONN_LIFETIME"`
+	DBQueryTimeout   int    `json:"dbQueryTimeout" env:"DB_QUERY_TIMEOUT"`
+	DBConnectRetries int    `json:"dbConnectRetries" env:"DB_CONNECT_RETRIES"`
+	TLSCert          string `json:"tlsCert" env:"TLS_CERT"`
+	TLSKey           string `json:"tlsKey" env:"TLS_KEY"`
+	TLSClientCA      string `json:"tlsClientCA" env:"TLS_CLIENT_CA"`
+	CryptoKey        string `json:"cryptoKey" env:"CRYPTO_KEY"`
+	TrustedSubnet    string `json:"trustedSubnet" env:"TRUSTED_SUBNET"`
+	GRPCBind         string `json:"grpcBind" env:"GRPC_ADDRESS"`
 }
 
 func (c *Config) String() string {
-	return fmt.Sprintf("[Config] Host:%s, StoreInterval:%v, FilePath:%s, Restore:%t, DatabaseDsn:%s",
+	return fmt.Sprintf("[Config] Host:%s, StoreInterval:%v, FilePath:%s, Restore:%t, DatabaseDsn:%s, History:%t, Retention:%v",
 		c.Bind,
 		c.StoreInterval,
 		c.FilePath,
 		c.Restore,
-		c.DatabaseDsn)
+		c.DatabaseDsn,
+		c.History,
+		c.Retention)
 }
 
 func InitConfig() (*Config, error) {
@@ -34,6 +56,26 @@ func InitConfig() (*Config, error) {
 	flag.StringVar(&cfg.FilePath, "f", "metrics.log", "путь до файла, куда сохраняются текущие значения")
 	flag.BoolVar(&cfg.Restore, "r", true, "булево значение (true/false), определяющее, загружать или нет ранее сохранённые значения из указанного файла при старте сервера")
 	flag.StringVar(&cfg.DatabaseDsn, "d", "", "адрес подключения к БД (env DATABASE_DSN) example: host=localhost user=postgres_user password=postgres_password dbname=postgres_db sslmode=disable")
+	flag.StringVar(&cfg.Key, "k", "", "ключ для подписи данных HMAC-SHA256 (env KEY)")
+	flag.BoolVar(&cfg.History, "history", false, "хранить историю значений метрик, а не только последнее значение (env HISTORY)")
+	flag.IntVar(&cfg.Retention, "retention", 86400, "время хранения истории в секундах, 0 - без ограничения (env HISTORY_RETENTION)")
+	flag.StringVar(&cfg.AlertRules, "alert-rules", "", "путь до json файла с правилами алертов, пустое значение отключает алерты (env ALERT_RULES)")
+	flag.IntVar(&cfg.AlertInterval, "alert-interval", 10, "интервал проверки правил алертов в секундах (env ALERT_INTERVAL)")
+	flag.StringVar(&cfg.AlertWebhooks, "alert-webhooks", "", "адреса webhook через запятую для уведомлений об алертах (env ALERT_WEBHOOKS)")
+	flag.IntVar(&cfg.AlertGroup, "alert-group", 10, "окно группировки уведомлений об алертах в секундах (env ALERT_GROUP)")
+	flag.IntVar(&cfg.RollupRetention, "rollup-retention", 2592000, "время хранения агрегатов истории в секундах, 0 - без ограничения (env ROLLUP_RETENTION)")
+	flag.BoolVar(&cfg.MigrateOnly, "migrate-only", false, "применить миграции схемы БД и завершить работу (env MIGRATE_ONLY)")
+	flag.IntVar(&cfg.DBMaxConns, "db-max-conns", 10, "максимальное число соединений с БД (env DB_MAX_CONNS)")
+	flag.IntVar(&cfg.DBMinConns, "db-min-conns", 0, "минимальное число открытых соединений с БД (env DB_MIN_CONNS)")
+	flag.IntVar(&cfg.DBConnLifetime, "db-conn-lifetime", 3600, "время жизни соединения с БД в секундах (env DB_CONN_LIFETIME)")
+	flag.IntVar(&cfg.DBQueryTimeout, "db-query-timeout", 5, "таймаут одного запроса к БД в секундах, 0 - без ограничения (env DB_QUERY_TIMEOUT)")
+	flag.IntVar(&cfg.DBConnectRetries, "db-connect-retries", 3, "число повторных попыток подключения к БД при старте (env DB_CONNECT_RETRIES)")
+	flag.StringVar(&cfg.TLSCert, "tls-cert", "", "путь до сертификата сервера в формате PEM, включает HTTPS (env TLS_CERT)")
+	flag.StringVar(&cfg.TLSKey, "tls-key", "", "путь до приватного ключа сертификата сервера (env TLS_KEY)")
+	flag.StringVar(&cfg.TLSClientCA, "tls-client-ca", "", "путь до CA клиентских сертификатов, включает обязательный mTLS (env TLS_CLIENT_CA)")
+	flag.StringVar(&cfg.CryptoKey, "crypto-key", "", "путь до приватного RSA ключа для расшифровки тел запросов (env CRYPTO_KEY)")
+	flag.StringVar(&cfg.TrustedSubnet, "t", "", "доверенная подсеть в CIDR, обновления метрик принимаются только от агентов из неё (env TRUSTED_SUBNET)")
+	flag.StringVar(&cfg.GRPCBind, "g", "", "адрес и порт gRPC сервера, пустое значение отключает gRPC (env GRPC_ADDRESS)")
 
 	flag.Parse()
 
@@ -56,6 +98,73 @@ func InitConfig() (*Config, error) {
 	if os.Getenv("DATABASE_DSN") != "" {
 		cfg.DatabaseDsn = envCfg.DatabaseDsn
 	}
+	if os.Getenv("KEY") != "" {
+		cfg.Key = envCfg.Key
+	}
+	if os.Getenv("HISTORY") != "" {
+		cfg.History = envCfg.History
+	}
+	if os.Getenv("HISTORY_RETENTION") != "" {
+		cfg.Retention = envCfg.Retention
+	}
+	if os.Getenv("ROLLUP_RETENTION") != "" {
+		cfg.RollupRetention = envCfg.RollupRetention
+	}
+	if os.Getenv("ALERT_RULES") != "" {
+		cfg.AlertRules = envCfg.AlertRules
+	}
+	if os.Getenv("ALERT_INTERVAL") != "" {
+		cfg.AlertInterval = envCfg.AlertInterval
+	}
+	if os.Getenv("ALERT_WEBHOOKS") != "" {
+		cfg.AlertWebhooks = envCfg.AlertWebhooks
+	}
+	if os.Getenv("ALERT_GROUP") != "" {
+		cfg.AlertGroup = envCfg.AlertGroup
+	}
+	if os.Getenv("MIGRATE_ONLY") != "" {
+		cfg.MigrateOnly = envCfg.MigrateOnly
+	}
+	if os.Getenv("DB_MAX_CONNS") != "" {
+		cfg.DBMaxConns = envCfg.DBMaxConns
+	}
+	if os.Getenv("DB_MIN_CONNS") != "" {
+		cfg.DBMinConns = envCfg.DBMinConns
+	}
+	if os.Getenv("DB_CONN_LIFETIME") != "" {
+		cfg.DBConnLifetime = envCfg.DBConnLifetime
+	}
+	if os.Getenv("DB_QUERY_TIMEOUT") != "" {
+		cfg.DBQueryTimeout = envCfg.DBQueryTimeout
+	}
+	if os.Getenv("DB_CONNECT_RETRIES") != "" {
+		cfg.DBConnectRetries = envCfg.DBConnectRetries
+	}
+	if os.Getenv("TLS_CERT") != "" {
+		cfg.TLSCert = envCfg.TLSCert
+	}
+	if os.Getenv("TLS_KEY") != "" {
+		cfg.TLSKey = envCfg.TLSKey
+	}
+	if os.Getenv("TLS_CLIENT_CA") != "" {
+		cfg.TLSClientCA = envCfg.TLSClientCA
+	}
+	if os.Getenv("CRYPTO_KEY") != "" {
+		cfg.CryptoKey = envCfg.CryptoKey
+	}
+	if os.Getenv("TRUSTED_SUBNET") != "" {
+		cfg.TrustedSubnet = envCfg.TrustedSubnet
+	}
+	if os.Getenv("GRPC_ADDRESS") != "" {
+		cfg.GRPCBind = envCfg.GRPCBind
+	}
+
+	if cfg.AlertInterval <= 0 {
+		return nil, fmt.Errorf("alert interval must be positive, got %d", cfg.AlertInterval)
+	}
+	if cfg.AlertGroup <= 0 {
+		return nil, fmt.Errorf("alert group window must be positive, got %d", cfg.AlertGroup)
+	}
 
 	return &cfg, nil
 }
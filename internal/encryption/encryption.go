@@ -0,0 +1,172 @@
+package encryption
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+)
+
+// keySize is the length of AES-256 key sealed with RSA for every message
+const keySize = 32
+
+// Encrypt seals data with a random AES-GCM key, the key itself is encrypted with RSA-OAEP.
+// Result is RSA encrypted key, then GCM nonce, then ciphertext
+func Encrypt(pub *rsa.PublicKey, data []byte) ([]byte, error) {
+	key := make([]byte, keySize)
+	if _, err := rand.Read(key); err != nil {
+		return nil, err
+	}
+	sealedKey, err := rsa.EncryptOAEP(sha256.New(), rand.Reader, pub, key, nil)
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err = rand.Read(nonce); err != nil {
+		return nil, err
+	}
+
+	out := make([]byte, 0, len(sealedKey)+len(nonce)+len(data)+gcm.Overhead())
+	out = append(out, sealedKey...)
+	out = append(out, nonce...)
+	return gcm.Seal(out, nonce, data, nil), nil
+}
+
+// Decrypt opens message produced by Encrypt
+func Decrypt(priv *rsa.PrivateKey, data []byte) ([]byte, error) {
+	size := priv.Size()
+	if len(data) < size {
+		return nil, errors.New("message too short")
+	}
+	key, err := rsa.DecryptOAEP(sha256.New(), nil, priv, data[:size], nil)
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	data = data[size:]
+	if len(data) < gcm.NonceSize() {
+		return nil, errors.New("message too short")
+	}
+	return gcm.Open(nil, data[:gcm.NonceSize()], data[gcm.NonceSize():], nil)
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// LoadPublicKey reads PEM encoded RSA public key or certificate
+func LoadPublicKey(path string) (*rsa.PublicKey, error) {
+	block, err := readPEM(path)
+	if err != nil {
+		return nil, err
+	}
+	var key any
+	switch block.Type {
+	case "RSA PUBLIC KEY":
+		key, err = x509.ParsePKCS1PublicKey(block.Bytes)
+	case "CERTIFICATE":
+		var cert *x509.Certificate
+		if cert, err = x509.ParseCertificate(block.Bytes); err == nil {
+			key = cert.PublicKey
+		}
+	default:
+		key, err = x509.ParsePKIXPublicKey(block.Bytes)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("cant parse public key %s: %w", path, err)
+	}
+	pub, ok := key.(*rsa.PublicKey)
+	if !ok {
+		return nil, fmt.Errorf("%s is not an RSA public key", path)
+	}
+	return pub, nil
+}
+
+// LoadPrivateKey reads PEM encoded RSA private key in PKCS#1 or PKCS#8 form
+func LoadPrivateKey(path string) (*rsa.PrivateKey, error) {
+	block, err := readPEM(path)
+	if err != nil {
+		return nil, err
+	}
+	if block.Type == "RSA PRIVATE KEY" {
+		return x509.ParsePKCS1PrivateKey(block.Bytes)
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("cant parse private key %s: %w", path, err)
+	}
+	priv, ok := key.(*rsa.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("%s is not an RSA private key", path)
+	}
+	return priv, nil
+}
+
+func readPEM(path string) (*pem.Block, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("no PEM data in %s", path)
+	}
+	return block, nil
+}
+
+// Middleware decrypts bodies of the JSON update routes the agent sends, these have to be encrypted.
+// URL-form updates and lookups have no secret body and pass as is. It does nothing when priv is nil
+func Middleware(priv *rsa.PrivateKey) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		if priv == nil {
+			return next
+		}
+		return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			if req.Method != http.MethodPost || (req.URL.Path != "/update/" && req.URL.Path != "/updates/") {
+				next.ServeHTTP(w, req)
+				return
+			}
+			data, err := io.ReadAll(req.Body)
+			if err != nil {
+				log.Printf("[ERR][DECRYPT] cant read body: %s", err)
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			if len(data) == 0 {
+				req.Body = io.NopCloser(bytes.NewReader(data))
+				next.ServeHTTP(w, req)
+				return
+			}
+			plain, err := Decrypt(priv, data)
+			if err != nil {
+				log.Printf("[ERR][DECRYPT] cant decrypt body: %s", err)
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			req.Body = io.NopCloser(bytes.NewReader(plain))
+			req.ContentLength = int64(len(plain))
+			next.ServeHTTP(w, req)
+		})
+	}
+}
@@ -0,0 +1,82 @@
+package encryption
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMiddleware(t *testing.T) {
+	priv, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+
+	// keys go through files the same way as -crypto-key does
+	dir := t.TempDir()
+	pubDer, err := x509.MarshalPKIXPublicKey(&priv.PublicKey)
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "public.pem"),
+		pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: pubDer}), 0600))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "private.pem"),
+		pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(priv)}), 0600))
+	pub, err := LoadPublicKey(filepath.Join(dir, "public.pem"))
+	require.NoError(t, err)
+	priv, err = LoadPrivateKey(filepath.Join(dir, "private.pem"))
+	require.NoError(t, err)
+
+	other, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+
+	body := []byte(`[{"id":"Alloc","type":"gauge","value":1}]`)
+	encrypt := func(pub *rsa.PublicKey) []byte {
+		data, err := Encrypt(pub, body)
+		require.NoError(t, err)
+		return data
+	}
+
+	h := Middleware(priv)(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		data, _ := io.ReadAll(req.Body)
+		w.WriteHeader(http.StatusOK)
+		w.Write(data)
+	}))
+
+	lookup := []byte(`{"id":"Alloc","type":"gauge"}`)
+
+	var tests = []struct {
+		name   string
+		url    string
+		body   []byte
+		status int
+		want   []byte
+	}{
+		{name: "encrypted", url: "/updates/", body: encrypt(pub), status: http.StatusOK, want: body},
+		{name: "encrypted single", url: "/update/", body: encrypt(pub), status: http.StatusOK, want: body},
+		{name: "other key", url: "/updates/", body: encrypt(&other.PublicKey), status: http.StatusBadRequest},
+		{name: "plain text", url: "/updates/", body: body, status: http.StatusBadRequest},
+		{name: "url form update", url: "/update/gauge/Alloc/1", status: http.StatusOK},
+		{name: "plain lookup", url: "/value/", body: lookup, status: http.StatusOK, want: lookup},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, test.url, bytes.NewReader(test.body))
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, req)
+
+			assert.Equal(t, test.status, rec.Code)
+			if test.status == http.StatusOK {
+				assert.Equal(t, test.want, rec.Body.Bytes())
+			}
+		})
+	}
+}
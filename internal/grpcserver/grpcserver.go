@@ -0,0 +1,110 @@
+package grpcserver
+
+import (
+	"context"
+	"errors"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/JohnRobertFord/go-plant/internal/config"
+	pb "github.com/JohnRobertFord/go-plant/internal/proto"
+	"github.com/JohnRobertFord/go-plant/internal/storage/metrics"
+	"github.com/JohnRobertFord/go-plant/internal/subnet"
+	"github.com/JohnRobertFord/go-plant/internal/tlsconfig"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/status"
+)
+
+type metricsServer struct {
+	pb.UnimplementedMetricsServer
+	ms metrics.Storage
+
+	mu sync.Mutex
+	// last stored frame by agent id, so frames resent after reconnect are not applied twice
+	committed map[string]commit
+	swept     time.Time
+	// closed on graceful stop to end streams, they never finish by themselves
+	quit chan struct{}
+}
+
+// Server is gRPC server whose GracefulStop also ends open streams
+type Server struct {
+	*grpc.Server
+	quit chan struct{}
+	once sync.Once
+}
+
+func (s *Server) GracefulStop() {
+	s.once.Do(func() { close(s.quit) })
+	s.Server.GracefulStop()
+}
+
+// New builds gRPC server on top of the same storage as HTTP one,
+// it uses TLS, trusted subnet and signing key from cfg. Messages are not encrypted
+// with CryptoKey, so it refuses to serve them in plaintext when the key is set
+func New(cfg *config.Config, ms metrics.Storage) (*Server, error) {
+	if cfg.CryptoKey != "" && cfg.TLSCert == "" {
+		return nil, errors.New("crypto key is not applied to gRPC, serve it with TLS certificate")
+	}
+	trusted, err := subnet.Parse(cfg.TrustedSubnet)
+	if err != nil {
+		return nil, err
+	}
+	opts := []grpc.ServerOption{
+		grpc.ChainUnaryInterceptor(Logging, Subnet(trusted), Sign(cfg.Key)),
+		grpc.ChainStreamInterceptor(StreamLogging, StreamSubnet(trusted), StreamSign(cfg.Key)),
+	}
+	if cfg.TLSCert != "" {
+		tlsCfg, err := tlsconfig.Server(cfg.TLSCert, cfg.TLSKey, cfg.TLSClientCA)
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, grpc.Creds(credentials.NewTLS(tlsCfg)))
+	}
+
+	s := &Server{Server: grpc.NewServer(opts...), quit: make(chan struct{})}
+	pb.RegisterMetricsServer(s.Server, &metricsServer{ms: ms, committed: make(map[string]commit), quit: s.quit})
+	return s, nil
+}
+
+func (s *metricsServer) UpdateMetrics(ctx context.Context, in *pb.UpdateMetricsRequest) (*pb.UpdateMetricsResponse, error) {
+	els := make([]metrics.Element, 0, len(in.GetMetrics()))
+	for _, m := range in.GetMetrics() {
+		el, err := m.Element()
+		if err != nil {
+			return nil, status.Error(codes.InvalidArgument, err.Error())
+		}
+		els = append(els, el)
+	}
+
+	out, err := s.ms.InsertBatch(ctx, els)
+	if err != nil {
+		log.Println(err)
+		if errors.Is(err, metrics.ErrBadMetric) {
+			return nil, status.Error(codes.InvalidArgument, err.Error())
+		}
+		return nil, status.Error(codes.Internal, "cant store metrics")
+	}
+
+	resp := &pb.UpdateMetricsResponse{Metrics: make([]*pb.Metric, 0, len(out))}
+	for _, el := range out {
+		resp.Metrics = append(resp.Metrics, pb.FromElement(el))
+	}
+	return resp, nil
+}
+
+func (s *metricsServer) GetMetric(ctx context.Context, in *pb.GetMetricRequest) (*pb.GetMetricResponse, error) {
+	mtype, err := pb.TypeName(in.GetType())
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	el, err := s.ms.Select(ctx, metrics.Element{ID: in.GetId(), MType: mtype, Labels: in.GetLabels()})
+	if err != nil {
+		log.Println(err)
+		return nil, status.Error(codes.NotFound, "metric not found")
+	}
+	return &pb.GetMetricResponse{Metric: pb.FromElement(*el)}, nil
+}
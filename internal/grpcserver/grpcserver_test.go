@@ -0,0 +1,154 @@
+package grpcserver
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/JohnRobertFord/go-plant/internal/config"
+	pb "github.com/JohnRobertFord/go-plant/internal/proto"
+	"github.com/JohnRobertFord/go-plant/internal/sign"
+	"github.com/JohnRobertFord/go-plant/internal/storage/metrics/cache"
+	"github.com/JohnRobertFord/go-plant/internal/subnet"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+// testClient serves cfg over in-memory connection
+func testClient(t *testing.T, cfg *config.Config) pb.MetricsClient {
+	s, err := New(cfg, cache.NewMemStorage(cfg))
+	require.NoError(t, err)
+
+	lis := bufconn.Listen(1 << 20)
+	go s.Serve(lis)
+	t.Cleanup(s.Stop)
+
+	conn, err := grpc.NewClient("passthrough:///bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) { return lis.DialContext(ctx) }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()))
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+	return pb.NewMetricsClient(conn)
+}
+
+func TestMetricsServer(t *testing.T) {
+	cfg := &config.Config{Key: "secret", TrustedSubnet: "10.0.0.0/8"}
+	client := testClient(t, cfg)
+
+	req := &pb.UpdateMetricsRequest{Metrics: []*pb.Metric{
+		{Id: "PollCount", Type: pb.Metric_COUNTER, Delta: 2},
+		{Id: "PollCount", Type: pb.Metric_COUNTER, Delta: 3},
+		{Id: "Alloc", Type: pb.Metric_GAUGE, Value: 1.5},
+	}}
+	payload, err := pb.Payload(req)
+	require.NoError(t, err)
+
+	var tests = []struct {
+		name string
+		ip   string
+		hash string
+		want codes.Code
+	}{
+		{name: "signed from trusted subnet", ip: "10.1.2.3", hash: sign.Sum(cfg.Key, payload), want: codes.OK},
+		{name: "untrusted subnet", ip: "192.168.0.1", hash: sign.Sum(cfg.Key, payload), want: codes.PermissionDenied},
+		{name: "wrong signature", ip: "10.1.2.3", hash: sign.Sum("other", payload), want: codes.Unauthenticated},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ctx := metadata.AppendToOutgoingContext(context.Background(),
+				subnet.Header, test.ip, sign.Header, test.hash)
+			_, err := client.UpdateMetrics(ctx, req)
+			assert.Equal(t, test.want, status.Code(err))
+		})
+	}
+
+	// reads are allowed without signature and from any address
+	got, err := client.GetMetric(context.Background(), &pb.GetMetricRequest{Id: "PollCount", Type: pb.Metric_COUNTER})
+	require.NoError(t, err)
+	assert.Equal(t, int64(5), got.GetMetric().GetDelta())
+
+	_, err = client.GetMetric(context.Background(), &pb.GetMetricRequest{Id: "Alloc", Type: pb.Metric_COUNTER})
+	assert.Equal(t, codes.NotFound, status.Code(err))
+}
+
+func TestStreamMetrics(t *testing.T) {
+	cfg := &config.Config{Key: "secret"}
+	client := testClient(t, cfg)
+
+	frame := func(seq uint64, delta int64) *pb.MetricFrame {
+		f := &pb.MetricFrame{Seq: seq, Metrics: []*pb.Metric{{Id: "PollCount", Type: pb.Metric_COUNTER, Delta: delta}}}
+		data, err := pb.FramePayload(f)
+		require.NoError(t, err)
+		f.Hash = sign.Sum(cfg.Key, data)
+		return f
+	}
+	// upload sends frames in one stream and returns the last acknowledged seq
+	upload := func(frames ...*pb.MetricFrame) uint64 {
+		ctx := metadata.AppendToOutgoingContext(context.Background(), AgentHeader, "agent-1")
+		stream, err := client.StreamMetrics(ctx)
+		require.NoError(t, err)
+		for _, f := range frames {
+			require.NoError(t, stream.Send(f))
+		}
+		require.NoError(t, stream.CloseSend())
+		var last uint64
+		for {
+			ack, err := stream.Recv()
+			if errors.Is(err, io.EOF) {
+				return last
+			}
+			require.NoError(t, err)
+			last = ack.GetCommittedSeq()
+		}
+	}
+
+	assert.Equal(t, uint64(2), upload(frame(1, 1), frame(2, 2)))
+	// after reconnect agent resends what was not acknowledged yet, stored frames are skipped
+	assert.Equal(t, uint64(3), upload(frame(2, 2), frame(3, 3)))
+
+	got, err := client.GetMetric(context.Background(), &pb.GetMetricRequest{Id: "PollCount", Type: pb.Metric_COUNTER})
+	require.NoError(t, err)
+	assert.Equal(t, int64(6), got.GetMetric().GetDelta())
+
+	bad := frame(4, 4)
+	bad.Hash = sign.Sum("other", []byte("x"))
+	ctx := metadata.AppendToOutgoingContext(context.Background(), AgentHeader, "agent-1")
+	stream, err := client.StreamMetrics(ctx)
+	require.NoError(t, err)
+	require.NoError(t, stream.Send(bad))
+	_, err = stream.Recv()
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+}
+
+func TestCommittedTTL(t *testing.T) {
+	ttl := CommittedTTL
+	CommittedTTL = 50 * time.Millisecond
+	defer func() { CommittedTTL = ttl }()
+
+	s := &metricsServer{committed: make(map[string]commit)}
+	s.setCommitted("agent-1", 5)
+	assert.Equal(t, uint64(5), s.lastCommitted("agent-1"))
+
+	time.Sleep(2 * CommittedTTL)
+	assert.Equal(t, uint64(0), s.lastCommitted("agent-1"), "idle agent is forgotten")
+
+	// the next write sweeps forgotten agents out
+	s.setCommitted("agent-2", 1)
+	assert.Len(t, s.committed, 1)
+}
+
+func TestNewNeedsTLSWithCryptoKey(t *testing.T) {
+	cfg := &config.Config{CryptoKey: "private.pem"}
+	_, err := New(cfg, cache.NewMemStorage(cfg))
+	assert.Error(t, err, "gRPC must not accept plaintext writes when bodies are meant to be encrypted")
+}
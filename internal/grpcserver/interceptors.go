@@ -0,0 +1,158 @@
+package grpcserver
+
+import (
+	"context"
+	"net"
+	"strings"
+	"time"
+
+	pb "github.com/JohnRobertFord/go-plant/internal/proto"
+	"github.com/JohnRobertFord/go-plant/internal/sign"
+	"github.com/JohnRobertFord/go-plant/internal/subnet"
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	gproto "google.golang.org/protobuf/proto"
+)
+
+// writeMethods change storage, subnet and signature checks apply only to them
+var writeMethods = map[string]bool{
+	pb.Metrics_UpdateMetrics_FullMethodName: true,
+	pb.Metrics_StreamMetrics_FullMethodName: true,
+}
+
+// Logging logs every call the same way as logger.Logging does for HTTP
+func Logging(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+	logger, err := zap.NewDevelopment()
+	if err != nil {
+		panic(err)
+	}
+	defer logger.Sync()
+
+	start := time.Now()
+	resp, err := handler(ctx, req)
+	logger.Sugar().Infoln(
+		"Method", info.FullMethod,
+		"Status", status.Code(err),
+		"Duration", time.Since(start),
+	)
+	return resp, err
+}
+
+// StreamLogging logs every stream when it ends
+func StreamLogging(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	logger, err := zap.NewDevelopment()
+	if err != nil {
+		panic(err)
+	}
+	defer logger.Sync()
+
+	start := time.Now()
+	err = handler(srv, ss)
+	logger.Sugar().Infoln(
+		"Method", info.FullMethod,
+		"Status", status.Code(err),
+		"Duration", time.Since(start),
+	)
+	return err
+}
+
+// Subnet rejects writes with PermissionDenied when x-real-ip metadata is outside trusted subnet
+func Subnet(trusted *net.IPNet) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		if trusted != nil && writeMethods[info.FullMethod] {
+			ip := first(ctx, subnet.Header)
+			if !subnet.Contains(trusted, ip) {
+				return nil, status.Errorf(codes.PermissionDenied, "%q is not in trusted subnet", ip)
+			}
+		}
+		return handler(ctx, req)
+	}
+}
+
+// StreamSubnet checks x-real-ip metadata once when a writing stream opens
+func StreamSubnet(trusted *net.IPNet) grpc.StreamServerInterceptor {
+	return func(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		if trusted != nil && writeMethods[info.FullMethod] {
+			ip := first(ss.Context(), subnet.Header)
+			if !subnet.Contains(trusted, ip) {
+				return status.Errorf(codes.PermissionDenied, "%q is not in trusted subnet", ip)
+			}
+		}
+		return handler(srv, ss)
+	}
+}
+
+// Sign requires writes to carry HMAC-SHA256 of the request in metadata when key is set
+func Sign(key string) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		if key != "" && writeMethods[info.FullMethod] {
+			if err := verify(ctx, key, req); err != nil {
+				return nil, err
+			}
+		}
+		return handler(ctx, req)
+	}
+}
+
+// StreamSign checks hash of every frame received from a writing stream when key is set
+func StreamSign(key string) grpc.StreamServerInterceptor {
+	return func(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		if key != "" && writeMethods[info.FullMethod] {
+			ss = &signedStream{ServerStream: ss, key: key}
+		}
+		return handler(srv, ss)
+	}
+}
+
+type signedStream struct {
+	grpc.ServerStream
+	key string
+}
+
+func (s *signedStream) RecvMsg(m any) error {
+	if err := s.ServerStream.RecvMsg(m); err != nil {
+		return err
+	}
+	frame, ok := m.(*pb.MetricFrame)
+	if !ok {
+		return nil
+	}
+	data, err := pb.FramePayload(frame)
+	if err != nil {
+		return status.Error(codes.Internal, err.Error())
+	}
+	if !sign.Valid(s.key, data, frame.GetHash()) {
+		return status.Errorf(codes.Unauthenticated, "bad signature of frame %d", frame.GetSeq())
+	}
+	return nil
+}
+
+func verify(ctx context.Context, key string, req any) error {
+	msg, ok := req.(gproto.Message)
+	if !ok {
+		return status.Error(codes.Internal, "request is not a protobuf message")
+	}
+	data, err := pb.Payload(msg)
+	if err != nil {
+		return status.Error(codes.Internal, err.Error())
+	}
+	if !sign.Valid(key, data, first(ctx, sign.Header)) {
+		return status.Error(codes.Unauthenticated, "bad signature")
+	}
+	return nil
+}
+
+// first returns the first value of metadata key, keys are case insensitive
+func first(ctx context.Context, key string) string {
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		return ""
+	}
+	if v := md.Get(strings.ToLower(key)); len(v) > 0 {
+		return v[0]
+	}
+	return ""
+}
@@ -0,0 +1,184 @@
+package grpcserver
+
+import (
+	"errors"
+	"io"
+	"log"
+	"sync"
+	"time"
+
+	pb "github.com/JohnRobertFord/go-plant/internal/proto"
+	"github.com/JohnRobertFord/go-plant/internal/storage/metrics"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// AgentHeader names the agent in stream metadata, frames of a known agent are deduplicated across streams
+const AgentHeader = "x-agent-id"
+
+// AckInterval is how often stream acknowledges stored frames
+var AckInterval = 500 * time.Millisecond
+
+// CommittedTTL is how long last stored seq of an idle agent is kept. Frames resent
+// after that are stored again, see StreamMetrics in metrics.proto
+var CommittedTTL = time.Hour
+
+type commit struct {
+	seq uint64
+	at  time.Time
+}
+
+// StreamMetrics stores frames as they arrive. Frames not newer than the last committed one
+// are skipped, invalid frames are logged and acknowledged so that agent drops them
+func (s *metricsServer) StreamMetrics(stream grpc.BidiStreamingServer[pb.MetricFrame, pb.StreamAck]) error {
+	ctx := stream.Context()
+	agent := first(ctx, AgentHeader)
+
+	var mu sync.Mutex
+	committed := s.lastCommitted(agent)
+	acked := committed
+
+	// only this goroutine sends until receiving is over
+	done := make(chan struct{})
+	ackDone := make(chan error, 1)
+	go func() {
+		var err error
+		defer func() { ackDone <- err }()
+		for {
+			select {
+			case <-done:
+				return
+			case <-time.After(AckInterval):
+			}
+			mu.Lock()
+			seq := committed
+			mu.Unlock()
+			if seq == acked {
+				continue
+			}
+			if err = stream.Send(&pb.StreamAck{CommittedSeq: seq}); err != nil {
+				return
+			}
+			acked = seq
+		}
+	}()
+	stop := func() error {
+		close(done)
+		return <-ackDone
+	}
+
+	// receiving goes on in its own goroutine, so that shutdown can end the stream
+	frames := make(chan *pb.MetricFrame)
+	recvErr := make(chan error, 1)
+	go func() {
+		for {
+			frame, err := stream.Recv()
+			if err != nil {
+				recvErr <- err
+				return
+			}
+			select {
+			case frames <- frame:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	var closing error
+loop:
+	for {
+		var frame *pb.MetricFrame
+		select {
+		case frame = <-frames:
+		case err := <-recvErr:
+			if !errors.Is(err, io.EOF) {
+				stop()
+				return err
+			}
+			break loop
+		case <-s.quit:
+			// agent reconnects to another server or after restart and resends what is not acknowledged
+			closing = status.Error(codes.Unavailable, "server is shutting down")
+			break loop
+		}
+		if frame.GetSeq() <= committed {
+			continue
+		}
+		if err := s.apply(stream, frame); err != nil {
+			stop()
+			return err
+		}
+		mu.Lock()
+		committed = frame.GetSeq()
+		mu.Unlock()
+		s.setCommitted(agent, committed)
+	}
+
+	if err := stop(); err != nil {
+		return err
+	}
+	// the final ack lets agent trim everything it sent before closing
+	if committed != acked {
+		if err := stream.Send(&pb.StreamAck{CommittedSeq: committed}); err != nil {
+			return err
+		}
+	}
+	return closing
+}
+
+func (s *metricsServer) apply(stream grpc.ServerStream, frame *pb.MetricFrame) error {
+	els := make([]metrics.Element, 0, len(frame.GetMetrics()))
+	for _, m := range frame.GetMetrics() {
+		el, err := m.Element()
+		if err != nil {
+			log.Printf("[ERR][STREAM] frame %d skipped: %s", frame.GetSeq(), err)
+			return nil
+		}
+		els = append(els, el)
+	}
+	_, err := s.ms.InsertBatch(stream.Context(), els)
+	if errors.Is(err, metrics.ErrBadMetric) {
+		log.Printf("[ERR][STREAM] frame %d skipped: %s", frame.GetSeq(), err)
+		return nil
+	}
+	if err != nil {
+		log.Println(err)
+		return status.Error(codes.Internal, "cant store metrics")
+	}
+	return nil
+}
+
+func (s *metricsServer) lastCommitted(agent string) uint64 {
+	if agent == "" {
+		return 0
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	c, ok := s.committed[agent]
+	if !ok || time.Since(c.at) > CommittedTTL {
+		return 0
+	}
+	return c.seq
+}
+
+func (s *metricsServer) setCommitted(agent string, seq uint64) {
+	if agent == "" {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	s.committed[agent] = commit{seq: seq, at: now}
+	// agents come and go, forgotten ones are swept out at most once per TTL
+	if now.Sub(s.swept) < CommittedTTL {
+		return
+	}
+	for id, c := range s.committed {
+		if now.Sub(c.at) > CommittedTTL {
+			delete(s.committed, id)
+		}
+	}
+	s.swept = now
+}
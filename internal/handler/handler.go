@@ -7,9 +7,12 @@ import (
 	"io"
 	"log"
 	"net/http"
+	"sort"
 	"strconv"
 	"strings"
+	"time"
 
+	"github.com/JohnRobertFord/go-plant/internal/alert"
 	"github.com/JohnRobertFord/go-plant/internal/storage/metrics"
 	"github.com/JohnRobertFord/go-plant/internal/storage/metrics/diskfile"
 	"github.com/go-chi/chi"
@@ -37,7 +40,7 @@ func GetAll(ms metrics.Storage) http.HandlerFunc {
 		}
 		var out []string
 		for _, el := range *list {
-			out = append(out, el.ID)
+			out = append(out, metrics.SeriesKey(el.ID, el.Labels))
 		}
 		w.Header().Set("Content-Type", "text/html")
 		w.WriteHeader(http.StatusOK)
@@ -45,6 +48,218 @@ func GetAll(ms metrics.Storage) http.HandlerFunc {
 
 	})
 }
+
+// Prometheus renders all stored metrics in the Prometheus text exposition format
+func Prometheus(ms metrics.Storage) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+
+		ctx := req.Context()
+		list, err := ms.SelectAll(ctx)
+		if err != nil {
+			log.Println("[ERR][SELECTALL] failed to get all metrics")
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		els := *list
+		sort.Slice(els, func(i, j int) bool {
+			ni, nj := PrometheusName(els[i].ID), PrometheusName(els[j].ID)
+			if ni != nj {
+				return ni < nj
+			}
+			if els[i].MType != els[j].MType {
+				return els[i].MType < els[j].MType
+			}
+			return metrics.SeriesKey(els[i].ID, els[i].Labels) < metrics.SeriesKey(els[j].ID, els[j].Labels)
+		})
+
+		// a name may be used by both a gauge and a counter, the counter then gets _total suffix
+		gauges := make(map[string]bool)
+		for _, el := range els {
+			if el.MType == "gauge" {
+				gauges[PrometheusName(el.ID)] = true
+			}
+		}
+
+		var b strings.Builder
+		var last string
+		for _, el := range els {
+			name := PrometheusName(el.ID)
+			if el.MType == "counter" && gauges[name] {
+				name += "_total"
+			}
+			var value string
+			switch {
+			case el.MType == "gauge" && el.Value != nil:
+				value = strconv.FormatFloat(*el.Value, 'g', -1, 64)
+			case el.MType == "counter" && el.Delta != nil:
+				value = strconv.FormatInt(*el.Delta, 10)
+			default:
+				continue
+			}
+			// all series of one name share a single TYPE line
+			if name != last {
+				fmt.Fprintf(&b, "# TYPE %s %s\n", name, el.MType)
+				last = name
+			}
+			fmt.Fprintf(&b, "%s%s %s\n", name, prometheusLabels(el.Labels), value)
+		}
+
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		w.WriteHeader(http.StatusOK)
+		io.WriteString(w, b.String())
+	})
+}
+
+// PrometheusName replaces characters not allowed in Prometheus metric names with '_'
+func PrometheusName(id string) string {
+	var b strings.Builder
+	for i, r := range id {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r == '_', r == ':':
+			b.WriteRune(r)
+		case r >= '0' && r <= '9':
+			if i == 0 {
+				b.WriteRune('_')
+			}
+			b.WriteRune(r)
+		default:
+			b.WriteRune('_')
+		}
+	}
+	if b.Len() == 0 {
+		return "_"
+	}
+	return b.String()
+}
+
+func prometheusLabels(labels map[string]string) string {
+	if len(labels) == 0 {
+		return ""
+	}
+	names := make([]string, 0, len(labels))
+	for k := range labels {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+
+	escape := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+	pairs := make([]string, 0, len(names))
+	for _, k := range names {
+		// label names don't allow ':'
+		name := strings.ReplaceAll(PrometheusName(k), ":", "_")
+		pairs = append(pairs, fmt.Sprintf(`%s="%s"`, name, escape.Replace(labels[k])))
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+// labelsFromQuery reads series labels from URL query, e.g. /value/gauge/Alloc?host=a,
+// reserved parameters are skipped
+func labelsFromQuery(req *http.Request, reserved ...string) map[string]string {
+	query := req.URL.Query()
+	for _, k := range reserved {
+		query.Del(k)
+	}
+	if len(query) == 0 {
+		return nil
+	}
+	labels := make(map[string]string, len(query))
+	for k, v := range query {
+		labels[k] = v[0]
+	}
+	return labels
+}
+
+// parseTime accepts unix seconds or RFC3339, empty value gives def
+func parseTime(s string, def time.Time) (time.Time, error) {
+	if s == "" {
+		return def, nil
+	}
+	if sec, err := strconv.ParseInt(s, 10, 64); err == nil {
+		return time.Unix(sec, 0).UTC(), nil
+	}
+	return time.Parse(time.RFC3339, s)
+}
+
+func GetHistory(ms metrics.Storage) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+
+		ctx := req.Context()
+		if !ms.GetConfig().History {
+			http.Error(w, "history is disabled", http.StatusNotFound)
+			return
+		}
+
+		query := req.URL.Query()
+		from, err := parseTime(query.Get("from"), time.Time{})
+		if err != nil {
+			http.Error(w, "bad from: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		to, err := parseTime(query.Get("to"), time.Now().UTC())
+		if err != nil {
+			http.Error(w, "bad to: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		el := metrics.Element{
+			ID:     chi.URLParam(req, "MetricID"),
+			MType:  chi.URLParam(req, "MetricType"),
+			Labels: labelsFromQuery(req, "from", "to", "step"),
+		}
+
+		// raw samples unless rolled up buckets are asked for with step=1m or step=1h
+		var out any
+		if query.Get("step") == "" {
+			samples, err := ms.History(ctx, el, from, to)
+			if err != nil {
+				log.Println(err)
+				w.WriteHeader(http.StatusInternalServerError)
+				return
+			}
+			if samples == nil {
+				samples = []metrics.Sample{}
+			}
+			out = samples
+		} else {
+			step, err := time.ParseDuration(query.Get("step"))
+			if err != nil || !metrics.IsRollupStep(step) {
+				http.Error(w, "bad step, want one of: 1m, 1h", http.StatusBadRequest)
+				return
+			}
+			rollups, err := ms.Rollups(ctx, el, step, from, to)
+			if err != nil {
+				log.Println(err)
+				w.WriteHeader(http.StatusInternalServerError)
+				return
+			}
+			if rollups == nil {
+				rollups = []metrics.Rollup{}
+			}
+			out = rollups
+		}
+
+		o, _ := json.Marshal(out)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		io.WriteString(w, fmt.Sprintf("%s\n", o))
+	})
+}
+
+func GetAlerts(e *alert.Engine) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+
+		alerts := []alert.Alert{}
+		if e != nil {
+			alerts = e.Alerts()
+		}
+		o, _ := json.Marshal(alerts)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		io.WriteString(w, fmt.Sprintf("%s\n", o))
+	})
+}
+
 func WriteMetric(ms metrics.Storage) http.HandlerFunc {
 	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
 
@@ -54,8 +269,9 @@ func WriteMetric(ms metrics.Storage) http.HandlerFunc {
 		input := chi.URLParam(req, "MetricValue")
 
 		el := metrics.Element{
-			ID:    metric,
-			MType: metrictype,
+			ID:     metric,
+			MType:  metrictype,
+			Labels: labelsFromQuery(req),
 		}
 
 		switch metrictype {
@@ -121,14 +337,15 @@ func WriteJSONMetric(ms metrics.Storage) http.HandlerFunc {
 				w.WriteHeader(http.StatusBadRequest)
 				return
 			}
-			var out []metrics.Element
-			for _, el := range in {
-				insrt, err := ms.Insert(ctx, el)
-				if err != nil {
-					log.Println(err)
-					continue
+			out, err := ms.InsertBatch(ctx, in)
+			if err != nil {
+				log.Println(err)
+				if errors.Is(err, metrics.ErrBadMetric) {
+					w.WriteHeader(http.StatusBadRequest)
+				} else {
+					w.WriteHeader(http.StatusInternalServerError)
 				}
-				out = append(out, *insrt)
+				return
 			}
 
 			o, _ := json.Marshal(out)
@@ -186,7 +403,7 @@ func GetMetric(ms metrics.Storage) http.HandlerFunc {
 		metrictype := chi.URLParam(req, "MetricType")
 		ID := chi.URLParam(req, "MetricID")
 
-		res, err := ms.Select(ctx, metrics.Element{ID: ID, MType: metrictype})
+		res, err := ms.Select(ctx, metrics.Element{ID: ID, MType: metrictype, Labels: labelsFromQuery(req)})
 		if err != nil {
 			log.Println(err)
 			w.WriteHeader(http.StatusNotFound)
@@ -0,0 +1,80 @@
+package proto
+
+//go:generate protoc --go_out=. --go_opt=paths=source_relative --go-grpc_out=. --go-grpc_opt=paths=source_relative metrics.proto
+
+import (
+	"fmt"
+
+	"github.com/JohnRobertFord/go-plant/internal/storage/metrics"
+	gproto "google.golang.org/protobuf/proto"
+)
+
+// Payload is the stable encoding of a message that gets signed with HMAC
+func Payload(m gproto.Message) ([]byte, error) {
+	return gproto.MarshalOptions{Deterministic: true}.Marshal(m)
+}
+
+// FramePayload is what the hash of a frame is computed over, the frame with empty hash
+func FramePayload(f *MetricFrame) ([]byte, error) {
+	c := gproto.Clone(f).(*MetricFrame)
+	c.Hash = ""
+	return Payload(c)
+}
+
+// TypeOf maps storage type name to its protobuf enum
+func TypeOf(mtype string) Metric_MType {
+	switch mtype {
+	case "gauge":
+		return Metric_GAUGE
+	case "counter":
+		return Metric_COUNTER
+	}
+	return Metric_UNSPECIFIED
+}
+
+// TypeName maps protobuf enum back to storage type name
+func TypeName(t Metric_MType) (string, error) {
+	switch t {
+	case Metric_GAUGE:
+		return "gauge", nil
+	case Metric_COUNTER:
+		return "counter", nil
+	}
+	return "", fmt.Errorf("%w: unknown type %s", metrics.ErrBadMetric, t)
+}
+
+func FromElement(el metrics.Element) *Metric {
+	m := &Metric{
+		Id:     el.ID,
+		Type:   TypeOf(el.MType),
+		Labels: el.Labels,
+	}
+	if el.Delta != nil {
+		m.Delta = *el.Delta
+	}
+	if el.Value != nil {
+		m.Value = *el.Value
+	}
+	return m
+}
+
+// Element converts message to storage element, only the field of its type is set
+func (m *Metric) Element() (metrics.Element, error) {
+	mtype, err := TypeName(m.GetType())
+	if err != nil {
+		return metrics.Element{}, err
+	}
+	el := metrics.Element{
+		ID:     m.GetId(),
+		MType:  mtype,
+		Labels: m.GetLabels(),
+	}
+	if mtype == "counter" {
+		delta := m.GetDelta()
+		el.Delta = &delta
+	} else {
+		value := m.GetValue()
+		el.Value = &value
+	}
+	return el, nil
+}
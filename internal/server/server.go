@@ -2,34 +2,74 @@ package server
 
 import (
 	"context"
+	"crypto/rsa"
+	"errors"
 	"log"
 	"net/http"
 	"strings"
 
+	"github.com/JohnRobertFord/go-plant/internal/alert"
 	"github.com/JohnRobertFord/go-plant/internal/compress"
 	"github.com/JohnRobertFord/go-plant/internal/config"
+	"github.com/JohnRobertFord/go-plant/internal/encryption"
 	"github.com/JohnRobertFord/go-plant/internal/handler"
 	"github.com/JohnRobertFord/go-plant/internal/logger"
+	"github.com/JohnRobertFord/go-plant/internal/sign"
 	"github.com/JohnRobertFord/go-plant/internal/storage/metrics"
+	"github.com/JohnRobertFord/go-plant/internal/subnet"
+	"github.com/JohnRobertFord/go-plant/internal/tlsconfig"
 	"github.com/go-chi/chi"
 )
 
 type server struct {
 	Server  *http.Server
 	storage metrics.Storage
+	cfg     *config.Config
 }
 
+// RunServer serves HTTPS when server certificate is configured and plain HTTP otherwise
 func (s server) RunServer() {
-	log.Fatal(s.Server.ListenAndServe())
-	s.Server.Shutdown(context.Background())
+	var err error
+	if s.cfg.TLSCert != "" {
+		s.Server.TLSConfig, err = tlsconfig.Server(s.cfg.TLSCert, s.cfg.TLSKey, s.cfg.TLSClientCA)
+		if err != nil {
+			log.Fatal(err)
+		}
+		err = s.Server.ListenAndServeTLS("", "")
+	} else {
+		err = s.Server.ListenAndServe()
+	}
+	if err != nil && !errors.Is(err, http.ErrServerClosed) {
+		log.Fatal(err)
+	}
+}
+
+// Shutdown stops accepting connections and waits for in-flight requests until ctx is done
+func (s server) Shutdown(ctx context.Context) error {
+	return s.Server.Shutdown(ctx)
 }
 
-func NewMetricServer(cfg *config.Config, ms metrics.Storage) *server {
+func NewMetricServer(cfg *config.Config, ms metrics.Storage, alerts *alert.Engine) *server {
+	trusted, err := subnet.Parse(cfg.TrustedSubnet)
+	if err != nil {
+		log.Fatalf("bad trusted subnet: %s", err)
+	}
+	var cryptoKey *rsa.PrivateKey
+	if cfg.CryptoKey != "" {
+		if cryptoKey, err = encryption.LoadPrivateKey(cfg.CryptoKey); err != nil {
+			log.Fatalf("cant load crypto key: %s", err)
+		}
+	}
+
 	r := chi.NewRouter()
-	r.Use(logger.Logging, compress.GzipMiddleware, Middleware)
+	// bodies are encrypted after compression on the agent, so decryption goes first
+	r.Use(logger.Logging, subnet.Middleware(trusted), encryption.Middleware(cryptoKey), compress.GzipMiddleware, sign.HashMiddleware(cfg.Key), Middleware)
 
 	r.Get("/", handler.GetAll(ms))
 	r.Get("/ping", handler.Ping(ms))
+	r.Get("/metrics", handler.Prometheus(ms))
+	r.Get("/history/{MetricType}/{MetricID}", handler.GetHistory(ms))
+	r.Get("/alerts", handler.GetAlerts(alerts))
 	r.Post("/updates/", handler.WriteJSONMetric(ms))
 	r.Route("/update/", func(r chi.Router) {
 		r.Post("/", handler.WriteJSONMetric(ms))
@@ -46,6 +86,7 @@ func NewMetricServer(cfg *config.Config, ms metrics.Storage) *server {
 			Handler: r,
 		},
 		storage: ms,
+		cfg:     cfg,
 	}
 }
 func Middleware(next http.Handler) http.Handler {
@@ -0,0 +1,80 @@
+package sign
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"net/http"
+)
+
+const Header = "HashSHA256"
+
+type signWriter struct {
+	http.ResponseWriter
+	buf    bytes.Buffer
+	status int
+}
+
+func (s *signWriter) Write(p []byte) (int, error) {
+	return s.buf.Write(p)
+}
+
+func (s *signWriter) WriteHeader(statusCode int) {
+	s.status = statusCode
+}
+
+// Sum returns hex encoded HMAC-SHA256 of data
+func Sum(key string, data []byte) string {
+	h := hmac.New(sha256.New, []byte(key))
+	h.Write(data)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+func Valid(key string, data []byte, hash string) bool {
+	got, err := hex.DecodeString(hash)
+	if err != nil {
+		return false
+	}
+	h := hmac.New(sha256.New, []byte(key))
+	h.Write(data)
+	return hmac.Equal(got, h.Sum(nil))
+}
+
+func HashMiddleware(key string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			if key == "" {
+				next.ServeHTTP(w, req)
+				return
+			}
+
+			if req.Method == http.MethodPost {
+				data, err := io.ReadAll(req.Body)
+				if err != nil {
+					http.Error(w, "Bad Request!", http.StatusBadRequest)
+					return
+				}
+				req.Body.Close()
+				if !Valid(key, data, req.Header.Get(Header)) {
+					http.Error(w, "Bad Request!", http.StatusBadRequest)
+					return
+				}
+				req.Body = io.NopCloser(bytes.NewReader(data))
+			}
+
+			sw := &signWriter{
+				ResponseWriter: w,
+				status:         http.StatusOK,
+			}
+			next.ServeHTTP(sw, req)
+
+			if sw.Header().Get("Content-Type") == "application/json" {
+				w.Header().Set(Header, Sum(key, sw.buf.Bytes()))
+			}
+			w.WriteHeader(sw.status)
+			w.Write(sw.buf.Bytes())
+		})
+	}
+}
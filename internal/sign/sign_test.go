@@ -0,0 +1,63 @@
+package sign
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHashMiddleware(t *testing.T) {
+	key := "secret"
+	body := `{"id":"Alloc","type":"gauge","value":1}`
+
+	h := HashMiddleware(key)(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		data, _ := io.ReadAll(req.Body)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		w.Write(data)
+	}))
+
+	var tests = []struct {
+		name   string
+		hash   string
+		status int
+	}{
+		{
+			name:   "valid signature",
+			hash:   Sum(key, []byte(body)),
+			status: http.StatusOK,
+		},
+		{
+			name:   "wrong signature",
+			hash:   Sum("other", []byte(body)),
+			status: http.StatusBadRequest,
+		},
+		{
+			name:   "no signature",
+			hash:   "",
+			status: http.StatusBadRequest,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "/update/", strings.NewReader(body))
+			if test.hash != "" {
+				req.Header.Set(Header, test.hash)
+			}
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, req)
+
+			assert.Equal(t, test.status, rec.Code)
+			if test.status == http.StatusOK {
+				require.Equal(t, body, rec.Body.String())
+				assert.Equal(t, Sum(key, []byte(body)), rec.Header().Get(Header))
+			}
+		})
+	}
+}
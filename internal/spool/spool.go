@@ -0,0 +1,181 @@
+package spool
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/JohnRobertFord/go-plant/internal/utils"
+)
+
+const segmentExt = ".seg"
+
+// Spool keeps unsent payloads as numbered segment files in a directory so
+// they survive agent restarts and can be replayed in the order they were put.
+type Spool struct {
+	dir     string
+	maxSize int64
+	maxAge  time.Duration
+
+	mu       sync.Mutex
+	seq      uint64
+	replayMu sync.Mutex
+}
+
+type segment struct {
+	seq  uint64
+	path string
+	size int64
+	mod  time.Time
+}
+
+// New opens spool in dir. maxSize limits total size of segments in bytes and
+// maxAge drops segments older than that, zero disables the limit.
+func New(dir string, maxSize int64, maxAge time.Duration) (*Spool, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	s := &Spool{
+		dir:     dir,
+		maxSize: maxSize,
+		maxAge:  maxAge,
+	}
+	segs, err := s.segments()
+	if err != nil {
+		return nil, err
+	}
+	if len(segs) > 0 {
+		s.seq = segs[len(segs)-1].seq
+	}
+	return s, nil
+}
+
+// Put stores data as a new segment and trims the spool to its limits.
+func (s *Spool) Put(data []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.seq++
+	name := filepath.Join(s.dir, fmt.Sprintf("%020d%s", s.seq, segmentExt))
+	tmp := name + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, name); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return s.trim()
+}
+
+// Len returns number of stored segments.
+func (s *Spool) Len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	segs, err := s.segments()
+	if err != nil {
+		return 0
+	}
+	return len(segs)
+}
+
+// Replay sends stored segments oldest first and removes every one that was
+// sent. Segments rejected with utils.ErrPermanent are dropped, as resending them
+// never helps. It stops on the first other failed send and returns its error.
+func (s *Spool) Replay(send func([]byte) error) error {
+	s.replayMu.Lock()
+	defer s.replayMu.Unlock()
+
+	s.mu.Lock()
+	err := s.trim()
+	segs, _ := s.segments()
+	s.mu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	for _, seg := range segs {
+		data, err := os.ReadFile(seg.path)
+		if err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return err
+		}
+		if err = send(data); err != nil {
+			if !errors.Is(err, utils.ErrPermanent) {
+				return err
+			}
+			log.Printf("[SPOOL] dropped segment %s, rejected: %s", filepath.Base(seg.path), err)
+		}
+		if err = os.Remove(seg.path); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+// trim drops expired segments and then the oldest ones until the spool fits
+// into maxSize. Caller must hold mu.
+func (s *Spool) trim() error {
+	segs, err := s.segments()
+	if err != nil {
+		return err
+	}
+
+	var total int64
+	for _, seg := range segs {
+		total += seg.size
+	}
+	for _, seg := range segs {
+		expired := s.maxAge > 0 && time.Since(seg.mod) > s.maxAge
+		oversize := s.maxSize > 0 && total > s.maxSize
+		if !expired && !oversize {
+			continue
+		}
+		if err = os.Remove(seg.path); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		total -= seg.size
+		log.Printf("[SPOOL] dropped segment %s, expired:%t, oversize:%t", filepath.Base(seg.path), expired, oversize)
+	}
+	return nil
+}
+
+func (s *Spool) segments() ([]segment, error) {
+	entries, err := os.ReadDir(s.dir)
+	if err != nil {
+		return nil, err
+	}
+	var out []segment
+	for _, e := range entries {
+		name := e.Name()
+		if e.IsDir() || !strings.HasSuffix(name, segmentExt) {
+			continue
+		}
+		seq, err := strconv.ParseUint(strings.TrimSuffix(name, segmentExt), 10, 64)
+		if err != nil {
+			continue
+		}
+		info, err := e.Info()
+		if err != nil {
+			continue
+		}
+		out = append(out, segment{
+			seq:  seq,
+			path: filepath.Join(s.dir, name),
+			size: info.Size(),
+			mod:  info.ModTime(),
+		})
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].seq < out[j].seq })
+	return out, nil
+}
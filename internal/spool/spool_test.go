@@ -0,0 +1,84 @@
+package spool
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/JohnRobertFord/go-plant/internal/utils"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReplayOrder(t *testing.T) {
+	s, err := New(t.TempDir(), 0, 0)
+	require.NoError(t, err)
+
+	for _, data := range []string{"first", "second", "third"} {
+		require.NoError(t, s.Put([]byte(data)))
+	}
+
+	// failed send keeps segment and everything after it
+	var got []string
+	err = s.Replay(func(b []byte) error {
+		if string(b) == "second" {
+			return errors.New("server down")
+		}
+		got = append(got, string(b))
+		return nil
+	})
+	require.Error(t, err)
+	assert.Equal(t, []string{"first"}, got)
+	assert.Equal(t, 2, s.Len())
+
+	got = nil
+	require.NoError(t, s.Replay(func(b []byte) error {
+		got = append(got, string(b))
+		return nil
+	}))
+	assert.Equal(t, []string{"second", "third"}, got)
+	assert.Equal(t, 0, s.Len())
+}
+
+func TestReplayRejected(t *testing.T) {
+	s, err := New(t.TempDir(), 0, 0)
+	require.NoError(t, err)
+
+	for _, data := range []string{"first", "bad", "third"} {
+		require.NoError(t, s.Put([]byte(data)))
+	}
+
+	// rejected segment is dropped and does not block the ones after it
+	var got []string
+	require.NoError(t, s.Replay(func(b []byte) error {
+		if string(b) == "bad" {
+			return fmt.Errorf("%w: 400 Bad Request", utils.ErrPermanent)
+		}
+		got = append(got, string(b))
+		return nil
+	}))
+	assert.Equal(t, []string{"first", "third"}, got)
+	assert.Equal(t, 0, s.Len())
+}
+
+func TestMaxSize(t *testing.T) {
+	dir := t.TempDir()
+	s, err := New(dir, 10, 0)
+	require.NoError(t, err)
+
+	for _, data := range []string{"aaaa", "bbbb", "cccc"} {
+		require.NoError(t, s.Put([]byte(data)))
+	}
+
+	// reopened spool continues numbering after existing segments
+	s, err = New(dir, 10, 0)
+	require.NoError(t, err)
+	require.NoError(t, s.Put([]byte("dddd")))
+
+	var got []string
+	require.NoError(t, s.Replay(func(b []byte) error {
+		got = append(got, string(b))
+		return nil
+	}))
+	assert.Equal(t, []string{"cccc", "dddd"}, got)
+}
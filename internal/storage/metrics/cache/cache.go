@@ -3,8 +3,9 @@ package cache
 import (
 	"context"
 	"fmt"
-	"log"
+	"sort"
 	"sync"
+	"time"
 
 	"github.com/JohnRobertFord/go-plant/internal/config"
 	"github.com/JohnRobertFord/go-plant/internal/storage/metrics"
@@ -15,22 +16,30 @@ type (
 	// counter int64
 
 	MemStorage struct {
-		mapa map[string]any
-		mu   sync.Mutex
-		cfg  *config.Config
+		mapa    map[string]metrics.Element
+		history map[string][]metrics.Sample
+		// rollups by step and series, compacted holds end of the last compacted bucket
+		rollups   map[time.Duration]map[string][]metrics.Rollup
+		compacted map[time.Duration]time.Time
+		mu        sync.Mutex
+		cfg       *config.Config
 	}
 )
 
 func NewMemStorage(c *config.Config) *MemStorage {
 	return &MemStorage{
-		mapa: make(map[string]any),
-		cfg:  c,
+		mapa:      make(map[string]metrics.Element),
+		history:   make(map[string][]metrics.Sample),
+		rollups:   make(map[time.Duration]map[string][]metrics.Rollup),
+		compacted: make(map[time.Duration]time.Time),
+		cfg:       c,
 	}
 }
 
 func (m *MemStorage) GetConfig() *config.Config {
 	return m.cfg
 }
+func (m *MemStorage) Close() {}
 func (m *MemStorage) Ping(context.Context) error {
 	return fmt.Errorf("no support storage")
 }
@@ -38,87 +47,193 @@ func (m *MemStorage) Insert(ctx context.Context, el metrics.Element) (*metrics.E
 	m.mu.Lock()
 	defer m.mu.Unlock()
 
-	out := metrics.Element{
-		ID:    el.ID,
-		MType: el.MType,
-	}
-	switch el.MType {
-	case "gauge":
-		m.mapa[el.ID] = *el.Value
-		out.Value = el.Value
-	case "counter":
-		if c, ok := m.mapa[el.ID].(int64); ok {
-			c += *el.Delta
-			m.mapa[el.ID] = c
-			out.Delta = &c
-		} else {
-			m.mapa[el.ID] = *el.Delta
-			out.Delta = el.Delta
-		}
-	default:
-		return nil, fmt.Errorf("[ERR][INSERT] cant insert metric %v", el)
+	if err := metrics.Validate(el); err != nil {
+		return nil, fmt.Errorf("[ERR][INSERT] cant insert metric: %w", err)
 	}
+	out := m.insert(el)
 	return &out, nil
 }
 
-func (m *MemStorage) Select(ctx context.Context, el metrics.Element) (*metrics.Element, error) {
+func (m *MemStorage) InsertBatch(ctx context.Context, els []metrics.Element) ([]metrics.Element, error) {
 	m.mu.Lock()
 	defer m.mu.Unlock()
 
-	out := metrics.Element{
-		ID:    el.ID,
-		MType: el.MType,
+	for _, el := range els {
+		if err := metrics.Validate(el); err != nil {
+			return nil, fmt.Errorf("[ERR][INSERT] cant insert batch: %w", err)
+		}
+	}
+	out := make([]metrics.Element, 0, len(els))
+	for _, el := range els {
+		out = append(out, m.insert(el))
 	}
+	return out, nil
+}
 
+func (m *MemStorage) Restore(ctx context.Context, els []metrics.Element) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, el := range els {
+		if err := metrics.Validate(el); err != nil {
+			return fmt.Errorf("[ERR][RESTORE] cant restore metrics: %w", err)
+		}
+	}
+	// snapshot holds counter totals, they replace stored values instead of being added
+	for _, el := range els {
+		delete(m.mapa, metrics.Key(el))
+		m.store(el)
+	}
+	return nil
+}
+
+// insert stores valid element and records it in history, caller must hold mu
+func (m *MemStorage) insert(el metrics.Element) metrics.Element {
+	out := m.store(el)
+	if m.cfg.History {
+		m.appendSample(el)
+	}
+	return out
+}
+
+// store sets gauge or adds counter delta, caller must hold mu
+func (m *MemStorage) store(el metrics.Element) metrics.Element {
+	key := metrics.Key(el)
+	out := metrics.Element{
+		ID:     el.ID,
+		MType:  el.MType,
+		Labels: el.Labels,
+	}
 	if el.MType == "gauge" {
-		if f, ok := m.mapa[el.ID].(float64); ok {
-			out.Value = &f
-		} else {
-			return nil, fmt.Errorf("metric not found")
+		v := *el.Value
+		out.Value = &v
+	} else {
+		c := *el.Delta
+		if ex, ok := m.mapa[key]; ok {
+			c += *ex.Delta
 		}
-	} else if el.MType == "counter" {
-		if c, ok := m.mapa[el.ID].(int64); ok {
-			out.Delta = &c
-		} else {
-			return nil, fmt.Errorf("metric not found")
+		out.Delta = &c
+	}
+	m.mapa[key] = out
+	return out
+}
+
+// appendSample records received value, samples past retention are dropped by Compact, caller must hold mu
+func (m *MemStorage) appendSample(el metrics.Element) {
+	key := metrics.Key(el)
+	now := time.Now().UTC()
+	sample := metrics.Sample{Time: now}
+	if el.MType == "counter" {
+		d := *el.Delta
+		sample.Delta = &d
+	} else {
+		v := *el.Value
+		sample.Value = &v
+	}
+	m.history[key] = append(m.history[key], sample)
+}
+
+func (m *MemStorage) History(ctx context.Context, el metrics.Element, from, to time.Time) ([]metrics.Sample, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	// unknown series has no samples, the same as in postgres
+	samples := m.history[metrics.Key(el)]
+	// samples past retention may wait for the next Compact to be dropped
+	if m.cfg.Retention > 0 {
+		oldest := time.Now().Add(-time.Duration(m.cfg.Retention) * time.Second)
+		if from.Before(oldest) {
+			from = oldest
 		}
 	}
+	start := sort.Search(len(samples), func(i int) bool { return !samples[i].Time.Before(from) })
+	end := sort.Search(len(samples), func(i int) bool { return samples[i].Time.After(to) })
+	if start >= end {
+		return []metrics.Sample{}, nil
+	}
+	return append([]metrics.Sample(nil), samples[start:end]...), nil
+}
 
-	return &out, nil
+func (m *MemStorage) Select(ctx context.Context, el metrics.Element) (*metrics.Element, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	ex, ok := m.mapa[metrics.Key(el)]
+	if !ok {
+		return nil, fmt.Errorf("metric not found")
+	}
+	return &ex, nil
 }
 
 func (m *MemStorage) SelectAll(ctx context.Context) (*[]metrics.Element, error) {
 	m.mu.Lock()
 	defer m.mu.Unlock()
 
-	var list []metrics.Element
-	var err error
+	list := make([]metrics.Element, 0, len(m.mapa))
+	for _, el := range m.mapa {
+		list = append(list, el)
+	}
+	return &list, nil
+}
 
-	for k, v := range m.mapa {
-		temp := metrics.Element{
-			ID: k,
+func (m *MemStorage) Compact(ctx context.Context, now time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, step := range metrics.RollupSteps {
+		start, end := m.compacted[step], now.Truncate(step)
+		if !end.After(start) {
+			continue
+		}
+		if m.rollups[step] == nil {
+			m.rollups[step] = make(map[string][]metrics.Rollup)
+		}
+		for key, samples := range m.history {
+			i := sort.Search(len(samples), func(i int) bool { return !samples[i].Time.Before(start) })
+			j := sort.Search(len(samples), func(i int) bool { return !samples[i].Time.Before(end) })
+			if rollups := metrics.Aggregate(samples[i:j], step); len(rollups) > 0 {
+				m.rollups[step][key] = append(m.rollups[step][key], rollups...)
+			}
 		}
-		switch vt := v.(type) {
-		case int64:
-			if c, ok := v.(int64); ok {
-				temp.MType = "counter"
-				temp.Delta = &c
-			} else {
-				log.Println("error getting counter")
-				err = fmt.Errorf("error getting metrics")
+		// series whose rollups are all expired are dropped, not kept with an empty slice
+		if m.cfg.RollupRetention > 0 {
+			oldest := now.Add(-time.Duration(m.cfg.RollupRetention) * time.Second)
+			for key, rollups := range m.rollups[step] {
+				k := sort.Search(len(rollups), func(i int) bool { return !rollups[i].Time.Before(oldest) })
+				if k == len(rollups) {
+					delete(m.rollups[step], key)
+					continue
+				}
+				m.rollups[step][key] = rollups[k:]
 			}
-		case float64:
-			if f, ok := v.(float64); ok {
-				temp.MType = "gauge"
-				temp.Value = &f
-			} else {
-				log.Println("error getting gauge")
-				err = fmt.Errorf("error getting metrics")
+		}
+		m.compacted[step] = end
+	}
+
+	// every series is trimmed, the ones that stopped reporting are dropped once empty
+	if m.cfg.Retention > 0 {
+		oldest := now.Add(-time.Duration(m.cfg.Retention) * time.Second)
+		for key, samples := range m.history {
+			i := sort.Search(len(samples), func(i int) bool { return !samples[i].Time.Before(oldest) })
+			if i == len(samples) {
+				delete(m.history, key)
+				continue
 			}
-		default:
-			log.Printf("unknown type: %v", vt)
+			m.history[key] = samples[i:]
 		}
-		list = append(list, temp)
 	}
-	return &list, err
+	return nil
+}
+
+func (m *MemStorage) Rollups(ctx context.Context, el metrics.Element, step time.Duration, from, to time.Time) ([]metrics.Rollup, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	rollups := m.rollups[step][metrics.Key(el)]
+	start := sort.Search(len(rollups), func(i int) bool { return !rollups[i].Time.Before(from) })
+	end := sort.Search(len(rollups), func(i int) bool { return rollups[i].Time.After(to) })
+	if start >= end {
+		return []metrics.Rollup{}, nil
+	}
+	return append([]metrics.Rollup(nil), rollups[start:end]...), nil
 }
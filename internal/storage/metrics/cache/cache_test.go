@@ -0,0 +1,204 @@
+package cache
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/JohnRobertFord/go-plant/internal/config"
+	"github.com/JohnRobertFord/go-plant/internal/storage/metrics"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestInsertBatch(t *testing.T) {
+	ctx := context.Background()
+	ms := NewMemStorage(&config.Config{})
+	delta := func(d int64) *int64 { return &d }
+
+	_, err := ms.InsertBatch(ctx, []metrics.Element{
+		{ID: "PollCount", MType: "counter", Delta: delta(1)},
+		{ID: "Broken", MType: "gauge"},
+	})
+	require.ErrorIs(t, err, metrics.ErrBadMetric)
+	_, err = ms.Select(ctx, metrics.Element{ID: "PollCount", MType: "counter"})
+	assert.Error(t, err, "failed batch must not be applied")
+
+	_, err = ms.InsertBatch(ctx, []metrics.Element{
+		{ID: "PollCount", MType: "counter", Delta: delta(1)},
+		{ID: "PollCount", MType: "counter", Delta: delta(2)},
+	})
+	require.NoError(t, err)
+	el, err := ms.Select(ctx, metrics.Element{ID: "PollCount", MType: "counter"})
+	require.NoError(t, err)
+	assert.Equal(t, int64(3), *el.Delta)
+}
+
+func TestSameNameDifferentTypes(t *testing.T) {
+	ctx := context.Background()
+	ms := NewMemStorage(&config.Config{})
+	delta, value := int64(5), 1.5
+
+	_, err := ms.Insert(ctx, metrics.Element{ID: "requests", MType: "counter", Delta: &delta})
+	require.NoError(t, err)
+	_, err = ms.Insert(ctx, metrics.Element{ID: "requests", MType: "gauge", Value: &value})
+	require.NoError(t, err)
+	_, err = ms.Insert(ctx, metrics.Element{ID: "requests", MType: "counter", Delta: &delta})
+	require.NoError(t, err)
+
+	c, err := ms.Select(ctx, metrics.Element{ID: "requests", MType: "counter"})
+	require.NoError(t, err)
+	assert.Equal(t, int64(10), *c.Delta)
+	g, err := ms.Select(ctx, metrics.Element{ID: "requests", MType: "gauge"})
+	require.NoError(t, err)
+	assert.Equal(t, 1.5, *g.Value)
+
+	list, err := ms.SelectAll(ctx)
+	require.NoError(t, err)
+	assert.Len(t, *list, 2)
+}
+
+func TestRestoreSkipsHistory(t *testing.T) {
+	ctx := context.Background()
+	ms := NewMemStorage(&config.Config{History: true})
+	total, delta := int64(1000), int64(1)
+	start := time.Now()
+
+	// restoring the same snapshot again keeps the total
+	for i := 0; i < 2; i++ {
+		require.NoError(t, ms.Restore(ctx, []metrics.Element{{ID: "PollCount", MType: "counter", Delta: &total}}))
+	}
+	_, err := ms.Insert(ctx, metrics.Element{ID: "PollCount", MType: "counter", Delta: &delta})
+	require.NoError(t, err)
+
+	el, err := ms.Select(ctx, metrics.Element{ID: "PollCount", MType: "counter"})
+	require.NoError(t, err)
+	assert.Equal(t, int64(1001), *el.Delta)
+
+	// restored total is not a sample, otherwise every restart shows up as a spike
+	samples, err := ms.History(ctx, metrics.Element{ID: "PollCount", MType: "counter"}, start, time.Now())
+	require.NoError(t, err)
+	require.Len(t, samples, 1)
+	assert.Equal(t, int64(1), *samples[0].Delta)
+}
+
+func TestHistory(t *testing.T) {
+	ctx := context.Background()
+	ms := NewMemStorage(&config.Config{History: true, Retention: 1})
+	series := metrics.Element{ID: "Load", MType: "gauge"}
+	set := func(v float64) time.Time {
+		_, err := ms.Insert(ctx, metrics.Element{ID: "Load", MType: "gauge", Value: &v})
+		require.NoError(t, err)
+		time.Sleep(10 * time.Millisecond)
+		return time.Now()
+	}
+
+	afterFirst := set(1)
+	afterSecond := set(2)
+	set(3)
+
+	var tests = []struct {
+		name     string
+		from, to time.Time
+		want     []float64
+	}{
+		{name: "everything", to: time.Now(), want: []float64{1, 2, 3}},
+		{name: "from", from: afterFirst, to: time.Now(), want: []float64{2, 3}},
+		{name: "to", to: afterSecond, want: []float64{1, 2}},
+		{name: "between", from: afterFirst, to: afterSecond, want: []float64{2}},
+		{name: "empty range", from: afterSecond, to: afterFirst, want: []float64{}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			samples, err := ms.History(ctx, series, test.from, test.to)
+			require.NoError(t, err)
+			got := make([]float64, 0, len(samples))
+			for _, s := range samples {
+				got = append(got, *s.Value)
+			}
+			assert.Equal(t, test.want, got)
+		})
+	}
+
+	// unknown series has no samples, the same as in postgres
+	samples, err := ms.History(ctx, metrics.Element{ID: "Unknown", MType: "gauge"}, time.Time{}, time.Now())
+	require.NoError(t, err)
+	assert.Empty(t, samples)
+
+	// samples past retention are not returned even before Compact drops them
+	time.Sleep(time.Second)
+	_, err = ms.Insert(ctx, metrics.Element{ID: "Idle", MType: "gauge", Value: new(float64)})
+	require.NoError(t, err)
+	time.Sleep(300 * time.Millisecond)
+	set(4)
+	samples, err = ms.History(ctx, series, time.Time{}, time.Now())
+	require.NoError(t, err)
+	require.Len(t, samples, 1)
+	assert.Equal(t, 4.0, *samples[0].Value)
+
+	// Compact trims every series, the one that stopped reporting is dropped once empty
+	require.NoError(t, ms.Compact(ctx, time.Now().Add(850*time.Millisecond)))
+	assert.Len(t, ms.history[metrics.Key(series)], 1)
+	assert.NotContains(t, ms.history, metrics.Key(metrics.Element{ID: "Idle", MType: "gauge"}))
+}
+
+func TestLabelsKeepSeriesApart(t *testing.T) {
+	ctx := context.Background()
+	ms := NewMemStorage(&config.Config{})
+	a, b, plain := 1.0, 2.0, 3.0
+
+	for _, el := range []metrics.Element{
+		{ID: "Load", MType: "gauge", Value: &a, Labels: map[string]string{"host": "a"}},
+		{ID: "Load", MType: "gauge", Value: &b, Labels: map[string]string{"host": "b"}},
+		{ID: "Load", MType: "gauge", Value: &plain},
+	} {
+		_, err := ms.Insert(ctx, el)
+		require.NoError(t, err)
+	}
+
+	var tests = []struct {
+		name   string
+		labels map[string]string
+		want   float64
+	}{
+		{name: "host a", labels: map[string]string{"host": "a"}, want: a},
+		{name: "host b", labels: map[string]string{"host": "b"}, want: b},
+		{name: "no labels", want: plain},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			el, err := ms.Select(ctx, metrics.Element{ID: "Load", MType: "gauge", Labels: test.labels})
+			require.NoError(t, err)
+			assert.Equal(t, test.want, *el.Value)
+			assert.Equal(t, test.labels, el.Labels)
+		})
+	}
+
+	_, err := ms.Select(ctx, metrics.Element{ID: "Load", MType: "gauge", Labels: map[string]string{"host": "c"}})
+	assert.Error(t, err)
+	list, err := ms.SelectAll(ctx)
+	require.NoError(t, err)
+	assert.Len(t, *list, 3)
+}
+
+func TestCompactDropsExpiredSeries(t *testing.T) {
+	ctx := context.Background()
+	ms := NewMemStorage(&config.Config{History: true, RollupRetention: 600})
+	series := metrics.Element{ID: "Load", MType: "gauge"}
+	v := 1.0
+	_, err := ms.Insert(ctx, metrics.Element{ID: "Load", MType: "gauge", Value: &v})
+	require.NoError(t, err)
+	now := time.Now()
+
+	require.NoError(t, ms.Compact(ctx, now.Add(2*time.Minute)))
+	rollups, err := ms.Rollups(ctx, series, time.Minute, time.Time{}, now.Add(time.Hour))
+	require.NoError(t, err)
+	require.Len(t, rollups, 1)
+	assert.Equal(t, 1.0, *rollups[0].Last)
+
+	// once every rollup of a series is past retention the series is gone
+	require.NoError(t, ms.Compact(ctx, now.Add(3*time.Hour)))
+	for _, step := range metrics.RollupSteps {
+		assert.Empty(t, ms.rollups[step], step.String())
+	}
+}
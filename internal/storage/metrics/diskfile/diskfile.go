@@ -3,14 +3,15 @@ package diskfile
 import (
 	"context"
 	"encoding/json"
-	"fmt"
 	"log"
 	"os"
-	"strings"
 
 	"github.com/JohnRobertFord/go-plant/internal/storage/metrics"
 )
 
+// Read4File restores snapshot written by Write2File. Every element carries its type,
+// so older snapshots load as is and a gauge and a counter with one name stay apart.
+// Restored values are not history samples, so they go through Restore and not Insert
 func Read4File(ctx context.Context, ms metrics.Storage) error {
 	filename := ms.GetConfig().FilePath
 	log.Printf("Restore from: %s", filename)
@@ -24,48 +25,51 @@ func Read4File(ctx context.Context, ms metrics.Storage) error {
 		return err
 	}
 
+	els := make([]metrics.Element, 0, len(in))
 	for _, el := range in {
 		if (el.MType == "gauge" && el.Value != nil) || (el.MType == "counter" && el.Delta != nil) {
-			ms.Insert(ctx, el)
+			els = append(els, el)
 		} else {
 			log.Printf("error read \"%s\" metric", el.ID)
 			continue
 		}
 	}
-	return nil
+	return ms.Restore(ctx, els)
 }
 
 func Write2File(ctx context.Context, ms metrics.Storage) error {
 
+	// metrics are read first, so that failed read does not truncate the last snapshot
+	list, err := ms.SelectAll(ctx)
+	if err != nil {
+		return err
+	}
 	file, err := os.OpenFile(ms.GetConfig().FilePath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0666)
 	if err != nil {
 		return err
 	}
 	defer file.Close()
 
-	var buf []string
-	list, err := ms.SelectAll(ctx)
-	if err != nil {
-		log.Println(err)
-	}
+	var buf []metrics.Element
 	for _, el := range *list {
 		switch el.MType {
 		case "counter":
 			// check to prevent 'panic: runtime error: invalid memory address or nil pointer dereference'
 			// when program quits
 			if el.Delta != nil {
-				buf = append(buf, fmt.Sprintf(
-					"{\"id\":\"%s\",\"type\":\"counter\",\"delta\":%v}", el.ID, *el.Delta))
+				buf = append(buf, el)
 			}
 		case "gauge":
 			// check to prevent 'panic ...'
 			if el.Value != nil {
-				buf = append(buf, fmt.Sprintf("{\"id\":\"%s\",\"type\":\"gauge\",\"value\":%v}", el.ID, *el.Value))
+				buf = append(buf, el)
 			}
 		default:
 			log.Printf("unknown type %s\n", el.MType)
 		}
 	}
-	_, err = fmt.Fprintf(file, "[%s]", strings.Join(buf, ","))
-	return err
+	if buf == nil {
+		buf = []metrics.Element{}
+	}
+	return json.NewEncoder(file).Encode(buf)
 }
@@ -0,0 +1,42 @@
+package diskfile
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+
+	"github.com/JohnRobertFord/go-plant/internal/config"
+	"github.com/JohnRobertFord/go-plant/internal/storage/metrics"
+	"github.com/JohnRobertFord/go-plant/internal/storage/metrics/cache"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRoundTripKeepsLabels(t *testing.T) {
+	ctx := context.Background()
+	cfg := &config.Config{FilePath: filepath.Join(t.TempDir(), "metrics.json")}
+	value, delta := 1.5, int64(7)
+
+	in := []metrics.Element{
+		{ID: "Load", MType: "gauge", Value: &value, Labels: map[string]string{"host": "a"}},
+		{ID: "Load", MType: "gauge", Value: &value},
+		{ID: "requests", MType: "counter", Delta: &delta, Labels: map[string]string{"host": "a", "service": "api"}},
+	}
+	ms := cache.NewMemStorage(cfg)
+	_, err := ms.InsertBatch(ctx, in)
+	require.NoError(t, err)
+	require.NoError(t, Write2File(ctx, ms))
+
+	restored := cache.NewMemStorage(cfg)
+	require.NoError(t, Read4File(ctx, restored))
+	for _, el := range in {
+		got, err := restored.Select(ctx, metrics.Element{ID: el.ID, MType: el.MType, Labels: el.Labels})
+		require.NoError(t, err, metrics.SeriesKey(el.ID, el.Labels))
+		assert.Equal(t, el.Labels, got.Labels)
+		assert.Equal(t, el.Value, got.Value)
+		assert.Equal(t, el.Delta, got.Delta)
+	}
+	list, err := restored.SelectAll(ctx)
+	require.NoError(t, err)
+	assert.Len(t, *list, len(in))
+}
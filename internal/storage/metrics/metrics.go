@@ -2,24 +2,146 @@ package metrics
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
 	"strconv"
+	"strings"
+	"time"
 
 	"github.com/JohnRobertFord/go-plant/internal/config"
 )
 
 type Element struct {
-	ID    string   `json:"id" db:"name"`
-	MType string   `json:"type" db:"type"`
-	Delta *int64   `json:"delta,omitempty" db:"delta"`
-	Value *float64 `json:"value,omitempty" db:"value"`
+	ID     string            `json:"id" db:"name"`
+	MType  string            `json:"type" db:"type"`
+	Delta  *int64            `json:"delta,omitempty" db:"delta"`
+	Value  *float64          `json:"value,omitempty" db:"value"`
+	Labels map[string]string `json:"labels,omitempty" db:"labels"`
+}
+
+// SeriesKey identifies a series by metric id and its labels, e.g. Alloc{host="a"}
+func SeriesKey(id string, labels map[string]string) string {
+	if len(labels) == 0 {
+		return id
+	}
+	names := make([]string, 0, len(labels))
+	for k := range labels {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+
+	var b strings.Builder
+	b.WriteString(id)
+	b.WriteByte('{')
+	for i, k := range names {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		b.WriteString(k)
+		b.WriteString("=")
+		b.WriteString(strconv.Quote(labels[k]))
+	}
+	b.WriteByte('}')
+	return b.String()
+}
+
+// Key identifies a stored series by type, id and labels, so a gauge and a counter may share a name
+func Key(el Element) string {
+	return el.MType + ":" + SeriesKey(el.ID, el.Labels)
+}
+
+// Sample is a single timestamped value of a series, Delta is the increment received for counters
+type Sample struct {
+	Time  time.Time `json:"time" db:"ts"`
+	Delta *int64    `json:"delta,omitempty" db:"delta"`
+	Value *float64  `json:"value,omitempty" db:"value"`
+}
+
+// Rollup aggregates samples of one bucket, gauges fill Min, Max, Avg and Last,
+// counters fill Sum and Rate per second
+type Rollup struct {
+	Time  time.Time `json:"time" db:"ts"`
+	Count int64     `json:"count" db:"count"`
+	Min   *float64  `json:"min,omitempty" db:"min"`
+	Max   *float64  `json:"max,omitempty" db:"max"`
+	Avg   *float64  `json:"avg,omitempty" db:"avg"`
+	Last  *float64  `json:"last,omitempty" db:"last"`
+	Sum   *int64    `json:"sum,omitempty" db:"sum"`
+	Rate  *float64  `json:"rate,omitempty" db:"rate"`
+}
+
+// RollupSteps are bucket sizes history is compacted into
+var RollupSteps = []time.Duration{time.Minute, time.Hour}
+
+func IsRollupStep(step time.Duration) bool {
+	for _, s := range RollupSteps {
+		if s == step {
+			return true
+		}
+	}
+	return false
+}
+
+// Aggregate groups samples sorted by time into step sized buckets
+func Aggregate(samples []Sample, step time.Duration) []Rollup {
+	var out []Rollup
+	for _, s := range samples {
+		bucket := s.Time.Truncate(step)
+		if len(out) == 0 || !out[len(out)-1].Time.Equal(bucket) {
+			out = append(out, Rollup{Time: bucket})
+		}
+		r := &out[len(out)-1]
+		r.Count++
+		if s.Delta != nil {
+			sum := *s.Delta
+			if r.Sum != nil {
+				sum += *r.Sum
+			}
+			rate := float64(sum) / step.Seconds()
+			r.Sum, r.Rate = &sum, &rate
+		}
+		if s.Value != nil {
+			v := *s.Value
+			min, max, avg, last := v, v, v, v
+			if r.Min != nil {
+				min = math.Min(*r.Min, v)
+				max = math.Max(*r.Max, v)
+				avg = *r.Avg + (v-*r.Avg)/float64(r.Count)
+			}
+			r.Min, r.Max, r.Avg, r.Last = &min, &max, &avg, &last
+		}
+	}
+	return out
+}
+
+var ErrBadMetric = errors.New("bad metric")
+
+// Validate checks that element has known type and the value for it
+func Validate(el Element) error {
+	if (el.MType != "gauge" || el.Value == nil) && (el.MType != "counter" || el.Delta == nil) {
+		return fmt.Errorf("%w: %s of type %q", ErrBadMetric, el.ID, el.MType)
+	}
+	return nil
 }
 
 type Storage interface {
 	Insert(context.Context, Element) (*Element, error)
+	// InsertBatch stores all elements or none of them
+	InsertBatch(context.Context, []Element) ([]Element, error)
+	// Restore loads elements of a snapshot, unlike InsertBatch it records no history samples
+	Restore(context.Context, []Element) error
 	Select(context.Context, Element) (*Element, error)
 	SelectAll(context.Context) (*[]Element, error)
+	History(ctx context.Context, el Element, from, to time.Time) ([]Sample, error)
+	Rollups(ctx context.Context, el Element, step time.Duration, from, to time.Time) ([]Rollup, error)
+	// Compact aggregates history samples of buckets completed by now into rollups
+	// and drops samples and rollups past their retention
+	Compact(ctx context.Context, now time.Time) error
 	Ping(context.Context) error
 	GetConfig() *config.Config
+	Close()
 }
 
 func IsCounter(input string) bool {
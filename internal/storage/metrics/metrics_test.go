@@ -0,0 +1,43 @@
+package metrics
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAggregate(t *testing.T) {
+	start := time.Date(2024, 1, 1, 10, 0, 0, 0, time.UTC)
+	value := func(v float64) *float64 { return &v }
+	delta := func(d int64) *int64 { return &d }
+
+	gauges := []Sample{
+		{Time: start.Add(10 * time.Second), Value: value(1)},
+		{Time: start.Add(20 * time.Second), Value: value(5)},
+		{Time: start.Add(30 * time.Second), Value: value(3)},
+		{Time: start.Add(70 * time.Second), Value: value(7)},
+	}
+	got := Aggregate(gauges, time.Minute)
+	require.Len(t, got, 2)
+	assert.Equal(t, start, got[0].Time)
+	assert.Equal(t, int64(3), got[0].Count)
+	assert.Equal(t, 1.0, *got[0].Min)
+	assert.Equal(t, 5.0, *got[0].Max)
+	assert.Equal(t, 3.0, *got[0].Avg)
+	assert.Equal(t, 3.0, *got[0].Last)
+	assert.Nil(t, got[0].Sum)
+	assert.Equal(t, start.Add(time.Minute), got[1].Time)
+	assert.Equal(t, 7.0, *got[1].Last)
+
+	counters := []Sample{
+		{Time: start.Add(10 * time.Second), Delta: delta(30)},
+		{Time: start.Add(50 * time.Second), Delta: delta(30)},
+	}
+	got = Aggregate(counters, time.Minute)
+	require.Len(t, got, 1)
+	assert.Equal(t, int64(60), *got[0].Sum)
+	assert.Equal(t, 1.0, *got[0].Rate)
+	assert.Nil(t, got[0].Min)
+}
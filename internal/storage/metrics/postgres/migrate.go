@@ -0,0 +1,125 @@
+package postgres
+
+import (
+	"context"
+	"embed"
+	"fmt"
+	"log"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+//go:embed migrations/*.sql
+var migrationFiles embed.FS
+
+// migrationLock is the advisory lock key held while migrations run,
+// so that several servers starting at once apply them only once
+const migrationLock = 7_041_970_118
+
+const (
+	createMigrationsQuery = `CREATE TABLE IF NOT EXISTS schema_migrations(
+	"version" int PRIMARY KEY,
+	"name" varchar(255) NOT NULL,
+	"applied_at" timestamptz NOT NULL DEFAULT now()
+	);`
+	appliedMigrationsQuery = `SELECT version FROM schema_migrations;`
+	insertMigrationQuery   = `INSERT INTO schema_migrations(version, name) VALUES($1, $2);`
+)
+
+type migration struct {
+	version int
+	name    string
+	sql     string
+}
+
+// migrations reads embedded files named like 0001_name.sql ordered by version
+func migrations() ([]migration, error) {
+	entries, err := migrationFiles.ReadDir("migrations")
+	if err != nil {
+		return nil, err
+	}
+	var out []migration
+	seen := make(map[int]string)
+	for _, e := range entries {
+		name := strings.TrimSuffix(e.Name(), ".sql")
+		prefix, _, _ := strings.Cut(name, "_")
+		version, err := strconv.Atoi(prefix)
+		if err != nil {
+			return nil, fmt.Errorf("bad migration name %s: %w", e.Name(), err)
+		}
+		if other, ok := seen[version]; ok {
+			return nil, fmt.Errorf("migrations %s and %s have the same version", other, e.Name())
+		}
+		seen[version] = e.Name()
+		data, err := migrationFiles.ReadFile(path.Join("migrations", e.Name()))
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, migration{version: version, name: name, sql: string(data)})
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].version < out[j].version })
+	return out, nil
+}
+
+// Migrate applies every migration missing from schema_migrations, each one in its own transaction
+func Migrate(ctx context.Context, db *pgxpool.Pool) error {
+	all, err := migrations()
+	if err != nil {
+		return err
+	}
+
+	// advisory locks belong to a session, so lock and unlock go through one connection
+	conn, err := db.Acquire(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Release()
+
+	if _, err = conn.Exec(ctx, "SELECT pg_advisory_lock($1)", migrationLock); err != nil {
+		return fmt.Errorf("cant take migration lock: %w", err)
+	}
+	defer func() {
+		if _, err := conn.Exec(context.Background(), "SELECT pg_advisory_unlock($1)", migrationLock); err != nil {
+			log.Printf("[ERR][MIGRATE] cant release migration lock: %s", err)
+		}
+	}()
+
+	if _, err = conn.Exec(ctx, createMigrationsQuery); err != nil {
+		return err
+	}
+	rows, err := conn.Query(ctx, appliedMigrationsQuery)
+	if err != nil {
+		return err
+	}
+	versions, err := pgx.CollectRows(rows, pgx.RowTo[int])
+	if err != nil {
+		return err
+	}
+	applied := make(map[int]bool, len(versions))
+	for _, v := range versions {
+		applied[v] = true
+	}
+
+	for _, m := range all {
+		if applied[m.version] {
+			continue
+		}
+		err = pgx.BeginFunc(ctx, conn, func(tx pgx.Tx) error {
+			if _, err := tx.Exec(ctx, m.sql); err != nil {
+				return err
+			}
+			_, err := tx.Exec(ctx, insertMigrationQuery, m.version, m.name)
+			return err
+		})
+		if err != nil {
+			return fmt.Errorf("migration %s failed: %w", m.name, err)
+		}
+		log.Printf("[MIGRATE] applied %s", m.name)
+	}
+	return nil
+}
@@ -0,0 +1,32 @@
+package postgres
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMigrations(t *testing.T) {
+	all, err := migrations()
+	require.NoError(t, err)
+	require.NotEmpty(t, all)
+	for i, m := range all {
+		assert.Equal(t, i+1, m.version, "migration %s is out of sequence", m.name)
+		assert.NotEmpty(t, m.sql)
+	}
+}
+
+func TestMigrateTwice(t *testing.T) {
+	p := testStorage(t)
+	ctx := context.Background()
+
+	require.NoError(t, Migrate(ctx, p.db))
+
+	var applied int
+	require.NoError(t, p.db.QueryRow(ctx, "SELECT count(*) FROM schema_migrations").Scan(&applied))
+	all, err := migrations()
+	require.NoError(t, err)
+	assert.Equal(t, len(all), applied)
+}
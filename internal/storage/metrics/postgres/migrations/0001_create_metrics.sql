@@ -0,0 +1,7 @@
+CREATE TABLE IF NOT EXISTS metrics(
+	"id" int generated always as identity,
+	"name" varchar(255) UNIQUE,
+	"type" varchar(50),
+	"value" double precision,
+	"delta" bigint
+);
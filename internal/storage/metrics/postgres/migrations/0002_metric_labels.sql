@@ -0,0 +1,3 @@
+ALTER TABLE metrics ADD COLUMN IF NOT EXISTS labels jsonb NOT NULL DEFAULT '{}';
+ALTER TABLE metrics DROP CONSTRAINT IF EXISTS metrics_name_key;
+CREATE UNIQUE INDEX IF NOT EXISTS metrics_name_labels_key ON metrics(name, labels);
@@ -0,0 +1,10 @@
+CREATE TABLE IF NOT EXISTS metrics_history(
+	"id" bigint generated always as identity,
+	"name" varchar(255) NOT NULL,
+	"type" varchar(50) NOT NULL,
+	"labels" jsonb NOT NULL DEFAULT '{}',
+	"value" double precision,
+	"delta" bigint,
+	"ts" timestamptz NOT NULL DEFAULT now()
+);
+CREATE INDEX IF NOT EXISTS metrics_history_series_ts ON metrics_history(name, type, labels, ts);
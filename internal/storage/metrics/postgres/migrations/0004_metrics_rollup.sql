@@ -0,0 +1,15 @@
+CREATE TABLE IF NOT EXISTS metrics_rollup(
+	"name" varchar(255) NOT NULL,
+	"type" varchar(50) NOT NULL,
+	"labels" jsonb NOT NULL DEFAULT '{}',
+	"step" int NOT NULL,
+	"ts" timestamptz NOT NULL,
+	"count" bigint NOT NULL,
+	"min" double precision,
+	"max" double precision,
+	"avg" double precision,
+	"last" double precision,
+	"sum" bigint,
+	"rate" double precision,
+	PRIMARY KEY (name, type, labels, step, ts)
+);
@@ -0,0 +1,6 @@
+-- a gauge and a counter with the same name are different series
+DELETE FROM metrics WHERE name IS NULL OR type IS NULL;
+ALTER TABLE metrics ALTER COLUMN name SET NOT NULL;
+ALTER TABLE metrics ALTER COLUMN type SET NOT NULL;
+DROP INDEX IF EXISTS metrics_name_labels_key;
+CREATE UNIQUE INDEX IF NOT EXISTS metrics_name_type_labels_key ON metrics(name, type, labels);
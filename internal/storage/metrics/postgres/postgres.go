@@ -2,39 +2,65 @@ package postgres
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
+	"fmt"
 	"log"
 	"sync"
+	"time"
 
 	"github.com/JohnRobertFord/go-plant/internal/config"
 	"github.com/JohnRobertFord/go-plant/internal/storage/metrics"
 	"github.com/JohnRobertFord/go-plant/internal/utils"
 	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
 	"github.com/jackc/pgx/v5/pgxpool"
 )
 
 const (
-	createTableQuery = `CREATE TABLE IF NOT EXISTS metrics(
-	"id" int generated always as identity,
-	"name" varchar(255) UNIQUE,
-	"type" varchar(50),
-	"value" double precision,
-	"delta" bigint
-	);`
-	insertWithConflictQuery = `INSERT INTO metrics(name, type, value, delta) VALUES($1,$2,$3,$4) ON CONFLICT (name) DO UPDATE SET value=$5, delta=$6 ;`
-	getAllMetricsQuery      = `SELECT name, type, value, delta FROM metrics;`
-	getOneMetricQuery       = `SELECT name, type, value, delta FROM metrics WHERE name=$1 AND type=$2;`
+	insertSampleQuery = `INSERT INTO metrics_history(name, type, labels, value, delta) VALUES($1,$2,$3,$4,$5);`
+	pruneHistoryQuery = `DELETE FROM metrics_history WHERE ts < $1;`
+	getHistoryQuery   = `SELECT ts, value, delta FROM metrics_history WHERE name=$1 AND type=$2 AND labels=$3 AND ts >= $4 AND ts <= $5 ORDER BY ts;`
+	compactQuery      = `INSERT INTO metrics_rollup(name, type, labels, step, ts, count, min, max, avg, last, sum, rate)
+	SELECT name, type, labels, $1::int, to_timestamp(floor(extract(epoch FROM ts) / $1::int) * $1::int) AS bucket,
+		count(*), min(value), max(value), avg(value), (array_agg(value ORDER BY ts DESC))[1],
+		sum(delta), sum(delta)::double precision / $1::int
+	FROM metrics_history
+	WHERE ts >= $2 AND ts < $3
+	GROUP BY name, type, labels, bucket
+	ON CONFLICT (name, type, labels, step, ts) DO UPDATE SET count=EXCLUDED.count, min=EXCLUDED.min, max=EXCLUDED.max,
+		avg=EXCLUDED.avg, last=EXCLUDED.last, sum=EXCLUDED.sum, rate=EXCLUDED.rate;`
+	pruneRollupQuery = `DELETE FROM metrics_rollup WHERE step=$1 AND ts < $2;`
+	lastRollupQuery  = `SELECT max(ts) FROM metrics_rollup WHERE step=$1;`
+	firstSampleQuery = `SELECT min(ts) FROM metrics_history;`
+	getRollupsQuery  = `SELECT ts, count, min, max, avg, last, sum, rate FROM metrics_rollup WHERE name=$1 AND type=$2 AND labels=$3 AND step=$4 AND ts >= $5 AND ts <= $6 ORDER BY ts;`
+	insertBatchQuery = `INSERT INTO metrics(name, type, value, delta, labels)
+	SELECT name, type, value, delta, labels::jsonb
+	FROM unnest($1::varchar[], $2::varchar[], $3::double precision[], $4::bigint[], $5::text[]) AS t(name, type, value, delta, labels)
+	ON CONFLICT (name, type, labels) DO UPDATE SET value=EXCLUDED.value, delta=metrics.delta + EXCLUDED.delta
+	RETURNING name, type, value, delta, labels;`
+	insertBatchSamplesQuery = `INSERT INTO metrics_history(name, type, labels, value, delta)
+	SELECT name, type, labels::jsonb, value, delta
+	FROM unnest($1::varchar[], $2::varchar[], $3::text[], $4::double precision[], $5::bigint[]) AS t(name, type, labels, value, delta);`
+	restoreQuery = `INSERT INTO metrics(name, type, value, delta, labels)
+	SELECT name, type, value, delta, labels::jsonb
+	FROM unnest($1::varchar[], $2::varchar[], $3::double precision[], $4::bigint[], $5::text[]) AS t(name, type, value, delta, labels)
+	ON CONFLICT (name, type, labels) DO UPDATE SET value=EXCLUDED.value, delta=EXCLUDED.delta;`
+	upsertQuery = `INSERT INTO metrics(name, type, value, delta, labels) VALUES($1,$2,$3,$4,$5)
+	ON CONFLICT (name, type, labels) DO UPDATE SET value=EXCLUDED.value, delta=metrics.delta + EXCLUDED.delta
+	RETURNING name, type, value, delta, labels;`
+	getAllMetricsQuery = `SELECT name, type, value, delta, labels FROM metrics;`
+	getOneMetricQuery  = `SELECT name, type, value, delta, labels FROM metrics WHERE name=$1 AND type=$2 AND labels=$3;`
 )
 
 type postgres struct {
 	db  *pgxpool.Pool
 	cfg *config.Config
-}
 
-var (
-	pgInstance *postgres
-	pgOnce     sync.Once
-)
+	mu sync.Mutex
+	// end of the last compacted bucket by step
+	compacted map[time.Duration]time.Time
+}
 
 func (p *postgres) Close() {
 	p.db.Close()
@@ -43,20 +69,74 @@ func (p *postgres) Close() {
 func NewPostgresStorage(c *config.Config) (*postgres, error) {
 
 	ctx := context.Background()
-	pgOnce.Do(func() {
-		dbPool, err := pgxpool.New(ctx, c.DatabaseDsn)
-		if err != nil {
-			log.Printf("unable to create connection pool: %s", err)
-			return
+	poolCfg, err := pgxpool.ParseConfig(c.DatabaseDsn)
+	if err != nil {
+		return nil, fmt.Errorf("bad database dsn: %w", err)
+	}
+	if c.DBMaxConns > 0 {
+		poolCfg.MaxConns = int32(c.DBMaxConns)
+	}
+	if c.DBMinConns > 0 {
+		poolCfg.MinConns = int32(c.DBMinConns)
+	}
+	if c.DBConnLifetime > 0 {
+		poolCfg.MaxConnLifetime = time.Duration(c.DBConnLifetime) * time.Second
+	}
+	dbPool, err := pgxpool.NewWithConfig(ctx, poolCfg)
+	if err != nil {
+		return nil, fmt.Errorf("unable to create connection pool: %w", err)
+	}
+	p := &postgres{
+		db:        dbPool,
+		cfg:       c,
+		compacted: make(map[time.Duration]time.Time),
+	}
+
+	// pool connects lazily, so make sure the database is there before serving
+	if err = p.waitReady(ctx); err != nil {
+		dbPool.Close()
+		return nil, fmt.Errorf("database is unreachable: %w", err)
+	}
+	if err = Migrate(ctx, dbPool); err != nil {
+		dbPool.Close()
+		return nil, fmt.Errorf("cant migrate schema: %w", err)
+	}
+	return p, nil
+}
+
+// waitReady pings database, trying DBConnectRetries more times with growing pauses
+func (p *postgres) waitReady(ctx context.Context) error {
+	for i := 0; ; i++ {
+		err := p.Ping(ctx)
+		if err == nil || i >= p.cfg.DBConnectRetries {
+			return err
 		}
-		pgInstance = &postgres{dbPool, c}
-	})
-	_, err := pgInstance.db.Exec(ctx, createTableQuery)
-	return pgInstance, err
+		wait := time.Duration(i+1) * time.Second
+		log.Printf("[ERR][DB] cant connect to database: %s, retry in %s", err, wait)
+		time.Sleep(wait)
+	}
+}
+
+// timeout bounds a single query with DBQueryTimeout
+func (p *postgres) timeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	if p.cfg.DBQueryTimeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, time.Duration(p.cfg.DBQueryTimeout)*time.Second)
+}
+
+// labels never returns nil, so that series without labels are stored as '{}' and not as json null
+func labels(el metrics.Element) map[string]string {
+	if el.Labels == nil {
+		return map[string]string{}
+	}
+	return el.Labels
 }
 
 func (p *postgres) Ping(ctx context.Context) error {
 
+	ctx, cancel := p.timeout(ctx)
+	defer cancel()
 	err := p.db.Ping(ctx)
 	if err != nil {
 		return err
@@ -67,8 +147,9 @@ func (p *postgres) Ping(ctx context.Context) error {
 func (p *postgres) SelectAll(ctx context.Context) (*[]metrics.Element, error) {
 
 	var out []metrics.Element
-	var err error
-	utils.Retry(ctx, func() error {
+	err := utils.Retry(ctx, func() error {
+		ctx, cancel := p.timeout(ctx)
+		defer cancel()
 		rows, err := p.db.Query(ctx, getAllMetricsQuery)
 		if err != nil {
 			return err
@@ -81,54 +162,233 @@ func (p *postgres) SelectAll(ctx context.Context) (*[]metrics.Element, error) {
 		}
 		return nil
 	})
-
-	return &out, err
+	if err != nil {
+		return nil, err
+	}
+	return &out, nil
 }
 func (p *postgres) Insert(ctx context.Context, el metrics.Element) (*metrics.Element, error) {
 
+	if err := metrics.Validate(el); err != nil {
+		return nil, fmt.Errorf("[ERR][INSERT] cant insert metric: %w", err)
+	}
+
+	var value *float64
+	var delta *int64
+	if el.MType == "counter" {
+		delta = el.Delta
+	} else {
+		value = el.Value
+	}
+
+	// counters are summed up by the upsert itself, so concurrent writers never lose increments
 	var out metrics.Element
-	utils.Retry(ctx, func() error {
-		switch el.MType {
-		case "gauge":
-			_, err := p.db.Exec(ctx, insertWithConflictQuery, el.ID, el.MType, el.Value, el.Delta, el.Value, el.Delta)
+	err := utils.Retry(ctx, func() error {
+		ctx, cancel := p.timeout(ctx)
+		defer cancel()
+		conn, err := p.db.Acquire(ctx)
+		if err != nil {
+			return err
+		}
+		defer conn.Release()
+		rows, err := conn.Query(ctx, upsertQuery, el.ID, el.MType, value, delta, labels(el))
+		if err == nil {
+			out, err = pgx.CollectOneRow(rows, pgx.RowToStructByName[metrics.Element])
+		}
+		if el.MType == "counter" {
+			return sent(err)
+		}
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	if p.cfg.History {
+		if err = p.addSample(ctx, el); err != nil {
+			log.Printf("[ERR][HISTORY] cant save sample of %s: %s", el.ID, err)
+		}
+	}
+	return &out, nil
+}
+
+// InsertBatch upserts all elements with a single statement in one transaction,
+// counters are summed up by the database
+func (p *postgres) InsertBatch(ctx context.Context, els []metrics.Element) ([]metrics.Element, error) {
+
+	for _, el := range els {
+		if err := metrics.Validate(el); err != nil {
+			return nil, fmt.Errorf("[ERR][INSERT] cant insert batch: %w", err)
+		}
+	}
+
+	merged := merge(els)
+	names, types, values, deltas, lbls, err := columns(merged)
+	if err != nil {
+		return nil, err
+	}
+
+	counters := false
+	for _, el := range merged {
+		counters = counters || el.MType == "counter"
+	}
+
+	var out []metrics.Element
+	err = utils.Retry(ctx, func() error {
+		ctx, cancel := p.timeout(ctx)
+		defer cancel()
+		conn, err := p.db.Acquire(ctx)
+		if err != nil {
+			return err
+		}
+		defer conn.Release()
+		err = pgx.BeginFunc(ctx, conn, func(tx pgx.Tx) error {
+			rows, err := tx.Query(ctx, insertBatchQuery, names, types, values, deltas, lbls)
 			if err != nil {
 				return err
 			}
-			return nil
-		case "counter":
-			rows, err := p.db.Query(ctx, getOneMetricQuery, el.ID, el.MType)
+			out, err = pgx.CollectRows(rows, pgx.RowToStructByName[metrics.Element])
 			if err != nil {
 				return err
 			}
-
-			var outDelta int64
-			ex, err := pgx.CollectOneRow(rows, pgx.RowToStructByName[metrics.Element])
-			if err != nil {
-				if !errors.Is(err, pgx.ErrNoRows) {
-					log.Printf("CollectRows error: %v, metric: %v", err, el.ID)
-					return err
-				}
-				outDelta = *el.Delta
-			} else {
-				outDelta = *ex.Delta + *el.Delta
-			}
-
-			out := metrics.Element{
-				ID:    el.ID,
-				MType: el.MType,
-				Delta: &outDelta,
+			if !p.cfg.History {
+				return nil
 			}
 
-			_, err = p.db.Exec(ctx, insertWithConflictQuery, out.ID, out.MType, out.Value, out.Delta, out.Value, out.Delta)
+			names, types, values, deltas, lbls, err := columns(els)
 			if err != nil {
-				log.Println(err)
 				return err
 			}
-			return nil
+			_, err = tx.Exec(ctx, insertBatchSamplesQuery, names, types, lbls, values, deltas)
+			return err
+		})
+		if counters {
+			return sent(err)
 		}
-		return nil
+		return err
 	})
-	return &out, nil
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// Restore writes snapshot elements with a single statement. Snapshot holds counter totals,
+// so they replace stored ones instead of being added, and no history samples are written
+func (p *postgres) Restore(ctx context.Context, els []metrics.Element) error {
+
+	for _, el := range els {
+		if err := metrics.Validate(el); err != nil {
+			return fmt.Errorf("[ERR][RESTORE] cant restore metrics: %w", err)
+		}
+	}
+	names, types, values, deltas, lbls, err := columns(merge(els))
+	if err != nil {
+		return err
+	}
+	return utils.Retry(ctx, func() error {
+		ctx, cancel := p.timeout(ctx)
+		defer cancel()
+		_, err := p.db.Exec(ctx, restoreQuery, names, types, values, deltas, lbls)
+		return err
+	})
+}
+
+// merge sums up counters and keeps the last gauge of repeated series,
+// as one row can't be upserted twice by a statement
+func merge(els []metrics.Element) []metrics.Element {
+	merged := make([]metrics.Element, 0, len(els))
+	index := make(map[string]int)
+	for _, el := range els {
+		key := metrics.Key(el)
+		i, ok := index[key]
+		if !ok {
+			index[key] = len(merged)
+			merged = append(merged, el)
+			continue
+		}
+		if el.MType == "counter" {
+			sum := *merged[i].Delta + *el.Delta
+			merged[i].Delta = &sum
+			continue
+		}
+		merged[i] = el
+	}
+	return merged
+}
+
+// sent stops Retry once a counter write may have reached the database, running
+// the upsert again would add the same deltas twice. Only errors that happened
+// before anything was sent are left to retry
+func sent(err error) error {
+	if err == nil || pgconn.SafeToRetry(err) {
+		return err
+	}
+	return fmt.Errorf("%w: %w", utils.ErrPermanent, err)
+}
+
+// columns splits elements into column arrays for unnest, labels are passed as json text
+func columns(els []metrics.Element) ([]string, []string, []*float64, []*int64, []string, error) {
+	names := make([]string, len(els))
+	types := make([]string, len(els))
+	values := make([]*float64, len(els))
+	deltas := make([]*int64, len(els))
+	lbls := make([]string, len(els))
+	for i, el := range els {
+		names[i], types[i] = el.ID, el.MType
+		if el.MType == "counter" {
+			deltas[i] = el.Delta
+		} else {
+			values[i] = el.Value
+		}
+		b, err := json.Marshal(labels(el))
+		if err != nil {
+			return nil, nil, nil, nil, nil, err
+		}
+		lbls[i] = string(b)
+	}
+	return names, types, values, deltas, lbls, nil
+}
+
+func (p *postgres) addSample(ctx context.Context, el metrics.Element) error {
+	var value *float64
+	var delta *int64
+	if el.MType == "counter" {
+		delta = el.Delta
+	} else {
+		value = el.Value
+	}
+	return utils.Retry(ctx, func() error {
+		ctx, cancel := p.timeout(ctx)
+		defer cancel()
+		_, err := p.db.Exec(ctx, insertSampleQuery, el.ID, el.MType, labels(el), value, delta)
+		return err
+	})
+}
+
+func (p *postgres) History(ctx context.Context, el metrics.Element, from, to time.Time) ([]metrics.Sample, error) {
+
+	// samples past retention may wait for the next Compact to be deleted
+	if p.cfg.Retention > 0 {
+		oldest := time.Now().Add(-time.Duration(p.cfg.Retention) * time.Second)
+		if from.Before(oldest) {
+			from = oldest
+		}
+	}
+	var out []metrics.Sample
+	err := utils.Retry(ctx, func() error {
+		ctx, cancel := p.timeout(ctx)
+		defer cancel()
+		rows, err := p.db.Query(ctx, getHistoryQuery, el.ID, el.MType, labels(el), from, to)
+		if err != nil {
+			return err
+		}
+		out, err = pgx.CollectRows(rows, pgx.RowToStructByName[metrics.Sample])
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
 }
 
 func (p *postgres) Select(ctx context.Context, el metrics.Element) (*metrics.Element, error) {
@@ -136,13 +396,16 @@ func (p *postgres) Select(ctx context.Context, el metrics.Element) (*metrics.Ele
 	var out metrics.Element
 
 	e := utils.Retry(ctx, func() error {
-		row, err := p.db.Query(ctx, getOneMetricQuery, el.ID, el.MType)
+		ctx, cancel := p.timeout(ctx)
+		defer cancel()
+		row, err := p.db.Query(ctx, getOneMetricQuery, el.ID, el.MType, labels(el))
 		if err != nil {
 			return err
 		}
 		out, err = pgx.CollectOneRow(row, pgx.RowToStructByName[metrics.Element])
-		if err != nil {
-			return err
+		// metric not reported yet, asking again will not help
+		if errors.Is(err, pgx.ErrNoRows) {
+			return fmt.Errorf("%w: metric not found: %w", utils.ErrPermanent, err)
 		}
 		return err
 	})
@@ -157,3 +420,95 @@ func (p *postgres) Select(ctx context.Context, el metrics.Element) (*metrics.Ele
 func (p *postgres) GetConfig() *config.Config {
 	return p.cfg
 }
+
+func (p *postgres) Compact(ctx context.Context, now time.Time) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for _, step := range metrics.RollupSteps {
+		end := now.Truncate(step)
+		start, ok := p.compacted[step]
+		if !ok {
+			var err error
+			if start, err = p.compactStart(ctx, step, end); err != nil {
+				return err
+			}
+		}
+		if !end.After(start) {
+			continue
+		}
+
+		seconds := int(step.Seconds())
+		err := utils.Retry(ctx, func() error {
+			ctx, cancel := p.timeout(ctx)
+			defer cancel()
+			_, err := p.db.Exec(ctx, compactQuery, seconds, start, end)
+			if err != nil {
+				return err
+			}
+			if p.cfg.RollupRetention > 0 {
+				oldest := now.Add(-time.Duration(p.cfg.RollupRetention) * time.Second)
+				_, err = p.db.Exec(ctx, pruneRollupQuery, seconds, oldest)
+			}
+			return err
+		})
+		if err != nil {
+			return err
+		}
+		p.compacted[step] = end
+	}
+
+	// one delete for all series, the ones that stopped reporting are pruned too
+	if p.cfg.Retention > 0 {
+		oldest := now.Add(-time.Duration(p.cfg.Retention) * time.Second)
+		return utils.Retry(ctx, func() error {
+			ctx, cancel := p.timeout(ctx)
+			defer cancel()
+			_, err := p.db.Exec(ctx, pruneHistoryQuery, oldest)
+			return err
+		})
+	}
+	return nil
+}
+
+// compactStart continues after the last stored rollup or from the first sample of history
+func (p *postgres) compactStart(ctx context.Context, step time.Duration, end time.Time) (time.Time, error) {
+	ctx, cancel := p.timeout(ctx)
+	defer cancel()
+
+	var last *time.Time
+	err := p.db.QueryRow(ctx, lastRollupQuery, int(step.Seconds())).Scan(&last)
+	if err != nil {
+		return end, err
+	}
+	if last != nil {
+		return last.Add(step), nil
+	}
+	var first *time.Time
+	if err = p.db.QueryRow(ctx, firstSampleQuery).Scan(&first); err != nil {
+		return end, err
+	}
+	if first != nil {
+		return first.Truncate(step), nil
+	}
+	return end, nil
+}
+
+func (p *postgres) Rollups(ctx context.Context, el metrics.Element, step time.Duration, from, to time.Time) ([]metrics.Rollup, error) {
+
+	var out []metrics.Rollup
+	err := utils.Retry(ctx, func() error {
+		ctx, cancel := p.timeout(ctx)
+		defer cancel()
+		rows, err := p.db.Query(ctx, getRollupsQuery, el.ID, el.MType, labels(el), int(step.Seconds()), from, to)
+		if err != nil {
+			return err
+		}
+		out, err = pgx.CollectRows(rows, pgx.RowToStructByName[metrics.Rollup])
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
@@ -0,0 +1,72 @@
+package postgres
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/JohnRobertFord/go-plant/internal/config"
+	"github.com/JohnRobertFord/go-plant/internal/storage/metrics"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testStorage connects to the database from TEST_DATABASE_DSN or skips the test
+func testStorage(t *testing.T) *postgres {
+	dsn := os.Getenv("TEST_DATABASE_DSN")
+	if dsn == "" {
+		t.Skip("TEST_DATABASE_DSN is not set")
+	}
+	p, err := NewPostgresStorage(&config.Config{DatabaseDsn: dsn})
+	require.NoError(t, err)
+	t.Cleanup(p.Close)
+	return p
+}
+
+func TestConcurrentCounter(t *testing.T) {
+	p := testStorage(t)
+	ctx := context.Background()
+
+	id := fmt.Sprintf("TestCounter%d", time.Now().UnixNano())
+	defer p.db.Exec(ctx, "DELETE FROM metrics WHERE name=$1", id)
+
+	const writers, increments = 20, 50
+	var wg sync.WaitGroup
+	for i := 0; i < writers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < increments; j++ {
+				one := int64(1)
+				_, err := p.Insert(ctx, metrics.Element{ID: id, MType: "counter", Delta: &one})
+				assert.NoError(t, err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	el, err := p.Select(ctx, metrics.Element{ID: id, MType: "counter"})
+	require.NoError(t, err)
+	assert.Equal(t, int64(writers*increments), *el.Delta)
+}
+
+func TestRestoreOverwrites(t *testing.T) {
+	p := testStorage(t)
+	ctx := context.Background()
+
+	id := fmt.Sprintf("TestRestore%d", time.Now().UnixNano())
+	defer p.db.Exec(ctx, "DELETE FROM metrics WHERE name=$1", id)
+
+	// every restart restores the same snapshot, the total must not grow
+	total := int64(42)
+	for i := 0; i < 3; i++ {
+		require.NoError(t, p.Restore(ctx, []metrics.Element{{ID: id, MType: "counter", Delta: &total}}))
+	}
+
+	el, err := p.Select(ctx, metrics.Element{ID: id, MType: "counter"})
+	require.NoError(t, err)
+	assert.Equal(t, total, *el.Delta)
+}
@@ -0,0 +1,60 @@
+package subnet
+
+import (
+	"log"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// Header carries address of the agent, it is set by the agent itself
+const Header = "X-Real-IP"
+
+// Parse reads subnet in CIDR notation, empty string means no restriction
+func Parse(cidr string) (*net.IPNet, error) {
+	if cidr == "" {
+		return nil, nil
+	}
+	_, trusted, err := net.ParseCIDR(cidr)
+	return trusted, err
+}
+
+// Contains reports whether ip is inside trusted subnet, any ip is allowed when trusted is nil
+func Contains(trusted *net.IPNet, ip string) bool {
+	if trusted == nil {
+		return true
+	}
+	addr := net.ParseIP(strings.TrimSpace(ip))
+	return addr != nil && trusted.Contains(addr)
+}
+
+// OutboundIP returns local address used to reach remote host:port, nothing is sent
+func OutboundIP(remote string) (string, error) {
+	conn, err := net.Dial("udp", remote)
+	if err != nil {
+		return "", err
+	}
+	defer conn.Close()
+	return conn.LocalAddr().(*net.UDPAddr).IP.String(), nil
+}
+
+// Middleware rejects writes to update routes with 403 when Header is outside trusted subnet,
+// read-only routes stay open
+func Middleware(trusted *net.IPNet) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		if trusted == nil {
+			return next
+		}
+		return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			if req.Method == http.MethodPost && strings.HasPrefix(req.URL.Path, "/update") {
+				ip := req.Header.Get(Header)
+				if !Contains(trusted, ip) {
+					log.Printf("[ERR][SUBNET] %q is not in trusted subnet %s", ip, trusted)
+					w.WriteHeader(http.StatusForbidden)
+					return
+				}
+			}
+			next.ServeHTTP(w, req)
+		})
+	}
+}
@@ -0,0 +1,46 @@
+package subnet
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMiddleware(t *testing.T) {
+	trusted, err := Parse("192.168.1.0/24")
+	require.NoError(t, err)
+
+	h := Middleware(trusted)(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	var tests = []struct {
+		name   string
+		method string
+		url    string
+		ip     string
+		status int
+	}{
+		{name: "trusted batch", method: http.MethodPost, url: "/updates/", ip: "192.168.1.10", status: http.StatusOK},
+		{name: "trusted update", method: http.MethodPost, url: "/update/gauge/Alloc/1", ip: "192.168.1.10", status: http.StatusOK},
+		{name: "untrusted batch", method: http.MethodPost, url: "/updates/", ip: "10.0.0.1", status: http.StatusForbidden},
+		{name: "no address", method: http.MethodPost, url: "/update/", ip: "", status: http.StatusForbidden},
+		{name: "read value", method: http.MethodPost, url: "/value/", ip: "10.0.0.1", status: http.StatusOK},
+		{name: "read all", method: http.MethodGet, url: "/", ip: "", status: http.StatusOK},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			req := httptest.NewRequest(test.method, test.url, nil)
+			if test.ip != "" {
+				req.Header.Set(Header, test.ip)
+			}
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, req)
+			assert.Equal(t, test.status, rec.Code)
+		})
+	}
+}
@@ -0,0 +1,115 @@
+package tlsconfig
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type issuer struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+}
+
+// writeCert generates certificate signed by parent, self-signed when parent is nil,
+// and writes it with its key as pem files into dir
+func writeCert(t *testing.T, dir, name string, parent *issuer, tmpl *x509.Certificate) *issuer {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	tmpl.SerialNumber = big.NewInt(time.Now().UnixNano())
+	tmpl.Subject = pkix.Name{CommonName: name}
+	tmpl.NotBefore = time.Now().Add(-time.Hour)
+	tmpl.NotAfter = time.Now().Add(time.Hour)
+
+	signer, signerKey := tmpl, key
+	if parent != nil {
+		signer, signerKey = parent.cert, parent.key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, signer, &key.PublicKey, signerKey)
+	require.NoError(t, err)
+	cert, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(filepath.Join(dir, name+".crt"),
+		pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, name+".key"),
+		pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}), 0600))
+	return &issuer{cert: cert, key: key}
+}
+
+func TestMutualTLS(t *testing.T) {
+	dir := t.TempDir()
+	path := func(name string) string { return filepath.Join(dir, name) }
+
+	ca := writeCert(t, dir, "ca", nil, &x509.Certificate{
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	})
+	writeCert(t, dir, "server", ca, &x509.Certificate{
+		IPAddresses: []net.IP{net.ParseIP("127.0.0.1")},
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	})
+	writeCert(t, dir, "agent", ca, &x509.Certificate{
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	})
+	writeCert(t, dir, "stranger", nil, &x509.Certificate{
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	})
+
+	serverCfg, err := Server(path("server.crt"), path("server.key"), path("ca.crt"))
+	require.NoError(t, err)
+	ts := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	ts.TLS = serverCfg
+	ts.StartTLS()
+	defer ts.Close()
+
+	var tests = []struct {
+		name    string
+		ca      string
+		cert    string
+		key     string
+		wantErr bool
+	}{
+		{name: "trusted client", ca: path("ca.crt"), cert: path("agent.crt"), key: path("agent.key")},
+		{name: "no client certificate", ca: path("ca.crt"), wantErr: true},
+		{name: "unknown client certificate", ca: path("ca.crt"), cert: path("stranger.crt"), key: path("stranger.key"), wantErr: true},
+		{name: "server not trusted", ca: path("stranger.crt"), cert: path("agent.crt"), key: path("agent.key"), wantErr: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			clientCfg, err := Client(test.ca, test.cert, test.key)
+			require.NoError(t, err)
+			client := &http.Client{Transport: &http.Transport{TLSClientConfig: clientCfg}}
+
+			resp, err := client.Get(ts.URL)
+			if test.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			defer resp.Body.Close()
+			assert.Equal(t, http.StatusOK, resp.StatusCode)
+		})
+	}
+}
@@ -2,10 +2,14 @@ package utils
 
 import (
 	"context"
+	"errors"
 	"log"
 	"time"
 )
 
+// ErrPermanent marks errors that repeating the call can't fix, Retry returns them at once
+var ErrPermanent = errors.New("permanent error")
+
 func Retry(ctx context.Context, f func() error) error {
 
 	var err error
@@ -14,10 +18,14 @@ func Retry(ctx context.Context, f func() error) error {
 			return err
 		}
 		err = f()
-		if err == nil {
-			return nil
+		if err == nil || errors.Is(err, ErrPermanent) {
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(time.Duration(i) * time.Second):
 		}
-		time.Sleep(time.Duration(i) * time.Second)
 		log.Printf("Retry func: %d", i)
 	}
 	return err
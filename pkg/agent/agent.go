@@ -0,0 +1,335 @@
+// Package agent polls collectors and reports their metrics to the server. Besides
+// cmd/agent, it lets a main of another module import its own collectors and call Run.
+package agent
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/rsa"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/JohnRobertFord/go-plant/internal/encryption"
+	pb "github.com/JohnRobertFord/go-plant/internal/proto"
+	"github.com/JohnRobertFord/go-plant/internal/sign"
+	"github.com/JohnRobertFord/go-plant/internal/spool"
+	"github.com/JohnRobertFord/go-plant/internal/storage/metrics"
+	"github.com/JohnRobertFord/go-plant/internal/subnet"
+	"github.com/JohnRobertFord/go-plant/internal/tlsconfig"
+	"github.com/JohnRobertFord/go-plant/internal/utils"
+	"github.com/JohnRobertFord/go-plant/pkg/collector"
+)
+
+const shutdownTimeout = 10 * time.Second
+
+// Config holds agent settings, cmd/agent fills it from flags and environment
+type Config struct {
+	// Address is host:port of the server
+	Address string
+	// Key signs reports with HMAC-SHA256, empty disables signing
+	Key string
+	// PollInterval and ReportInterval are in seconds
+	PollInterval   int
+	ReportInterval int
+	// BatchSize is max metrics per batch, 0 sends all at once
+	BatchSize int
+	// RateLimit is max concurrent outgoing requests
+	RateLimit int
+	// Collectors to enable, all registered if empty, except DisabledCollectors
+	Collectors         []string
+	DisabledCollectors []string
+	// SpoolDir keeps unsent batches, disabled if empty. Zero limits are unlimited
+	SpoolDir     string
+	SpoolMaxSize int64
+	SpoolMaxAge  int
+	// Labels are added to every metric
+	Labels map[string]string
+	// TLSCA or TLSCert enable TLS, TLSCert and TLSKey are the client certificate for mTLS
+	TLSCA   string
+	TLSCert string
+	TLSKey  string
+	// Transport is http, grpc or grpc-stream
+	Transport string
+	// CryptoKey is server RSA public key to encrypt HTTP bodies with
+	CryptoKey string
+}
+
+type agent struct {
+	cfg        Config
+	scheme     string
+	client     *http.Client
+	publicKey  *rsa.PublicKey
+	realIP     string
+	sendQueue  *spool.Spool
+	grpcClient pb.MetricsClient
+	streamer   *Streamer
+}
+
+// Run reports metrics of cfg.Collectors until ctx is done, then polls them once more
+// and sends the last report, waiting for it no longer than shutdown timeout
+func Run(ctx context.Context, cfg Config) error {
+	if cfg.RateLimit < 1 {
+		cfg.RateLimit = 1
+	}
+	a := &agent{cfg: cfg, scheme: "http", client: http.DefaultClient}
+
+	var err error
+	var tlsCfg *tls.Config
+	if cfg.TLSCA != "" || cfg.TLSCert != "" {
+		if tlsCfg, err = tlsconfig.Client(cfg.TLSCA, cfg.TLSCert, cfg.TLSKey); err != nil {
+			return err
+		}
+		a.scheme = "https"
+		a.client = &http.Client{Transport: &http.Transport{TLSClientConfig: tlsCfg}}
+	}
+
+	switch cfg.Transport {
+	case "", "http":
+	case "grpc", "grpc-stream":
+		// bodies are encrypted only over HTTP, gRPC relies on TLS to keep values secret
+		if cfg.CryptoKey != "" && tlsCfg == nil {
+			return fmt.Errorf("crypto key is not applied to %s transport, use TLS to encrypt it", cfg.Transport)
+		}
+		conn, err := a.dialGRPC(tlsCfg)
+		if err != nil {
+			return fmt.Errorf("cant connect to grpc server: %w", err)
+		}
+		defer conn.Close()
+	default:
+		return fmt.Errorf("unknown transport %q, want http, grpc or grpc-stream", cfg.Transport)
+	}
+
+	if cfg.CryptoKey != "" {
+		if a.publicKey, err = encryption.LoadPublicKey(cfg.CryptoKey); err != nil {
+			return err
+		}
+	}
+
+	// server may accept updates only from a trusted subnet, so it is told which address we use
+	if a.realIP, err = subnet.OutboundIP(cfg.Address); err != nil {
+		log.Printf("[ERR][SUBNET] cant find outbound address: %s", err)
+	}
+
+	if cfg.SpoolDir != "" {
+		q, err := spool.New(cfg.SpoolDir, cfg.SpoolMaxSize, time.Duration(cfg.SpoolMaxAge)*time.Second)
+		if err != nil {
+			return fmt.Errorf("cant open spool: %w", err)
+		}
+		a.sendQueue = q
+	}
+
+	cs, err := collector.New(cfg.Collectors, cfg.DisabledCollectors)
+	if err != nil {
+		return err
+	}
+
+	// sends outlive ctx, so that queued reports and the final one get a chance
+	// to reach the server until the shutdown deadline
+	sendCtx, cancelSend := context.WithCancel(context.Background())
+	defer cancelSend()
+
+	// stream lives until the last frames are acknowledged or shutdown deadline passes
+	streamCtx, stopStream := context.WithCancel(context.Background())
+	defer stopStream()
+	if cfg.Transport == "grpc-stream" {
+		a.streamer = a.newStreamer()
+		go a.streamer.Run(streamCtx)
+	}
+
+	var wg sync.WaitGroup
+	jobs := make(chan []metrics.Element, cfg.RateLimit)
+	for w := 0; w < cfg.RateLimit; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			a.worker(sendCtx, jobs, cs)
+		}()
+	}
+
+	var pollers sync.WaitGroup
+	for _, c := range cs {
+		c.Poll()
+		pollers.Add(1)
+		go func(c collector.Collector) {
+			defer pollers.Done()
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case <-time.After(time.Duration(cfg.PollInterval) * time.Second):
+				}
+				c.Poll()
+			}
+		}(c)
+	}
+	jobs <- a.collect(cs)
+
+	// batch collected but not queued yet, it goes with the last report so counters are not lost
+	var pending []metrics.Element
+loop:
+	for {
+		select {
+		case <-ctx.Done():
+			break loop
+		case <-time.After(time.Duration(cfg.ReportInterval) * time.Second):
+		}
+		pending = a.collect(cs)
+		select {
+		case <-ctx.Done():
+			break loop
+		case jobs <- pending:
+			pending = nil
+		}
+	}
+
+	log.Println("[SHUTDOWN] sending last report")
+	pollers.Wait()
+	for _, c := range cs {
+		c.Poll()
+	}
+	time.AfterFunc(shutdownTimeout, cancelSend)
+	jobs <- append(pending, a.collect(cs)...)
+	close(jobs)
+	wg.Wait()
+	if a.streamer != nil {
+		a.streamer.Flush(sendCtx, stopStream)
+	}
+	log.Println("[SHUTDOWN] done")
+	return nil
+}
+
+func compress(data []byte) ([]byte, error) {
+	var b bytes.Buffer
+	w, err := gzip.NewWriterLevel(&b, gzip.BestCompression)
+	if err != nil {
+		return nil, err
+	}
+	if _, err = w.Write(data); err != nil {
+		return nil, err
+	}
+	if err = w.Close(); err != nil {
+		return nil, err
+	}
+	return b.Bytes(), nil
+}
+
+func (a *agent) sendBatch(ctx context.Context, els []metrics.Element) error {
+
+	ret, err := json.Marshal(els)
+	if err != nil {
+		return err
+	}
+	if a.sendQueue == nil {
+		return a.sendRaw(ctx, ret)
+	}
+
+	// older batches go first, while they can't be delivered new ones queue up behind them.
+	// A batch server rejected is not queued, resending it gives the same answer
+	err = a.sendQueue.Replay(func(data []byte) error {
+		return a.sendRaw(ctx, data)
+	})
+	if err == nil {
+		if err = a.sendRaw(ctx, ret); err == nil || errors.Is(err, utils.ErrPermanent) {
+			return err
+		}
+	}
+	if perr := a.sendQueue.Put(ret); perr != nil {
+		log.Printf("[ERR][SPOOL] cant queue batch: %s", perr)
+		return err
+	}
+	log.Printf("[SPOOL] batch queued: %s", err)
+	return nil
+}
+
+func (a *agent) sendRaw(ctx context.Context, ret []byte) error {
+	switch a.cfg.Transport {
+	case "grpc":
+		return a.sendGRPC(ctx, ret)
+	case "grpc-stream":
+		return a.streamer.Push(ret)
+	}
+	body, err := compress(ret)
+	if err != nil {
+		return err
+	}
+	if a.publicKey != nil {
+		if body, err = encryption.Encrypt(a.publicKey, body); err != nil {
+			return err
+		}
+	}
+	return utils.Retry(ctx, func() error {
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, a.scheme+"://"+a.cfg.Address+"/updates/", bytes.NewReader(body))
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Content-Encoding", "gzip")
+		if a.realIP != "" {
+			req.Header.Set(subnet.Header, a.realIP)
+		}
+		if a.cfg.Key != "" {
+			req.Header.Set(sign.Header, sign.Sum(a.cfg.Key, ret))
+		}
+		resp, err := a.client.Do(req)
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			if rejected(resp.StatusCode) {
+				return fmt.Errorf("%w: unexpected status: %s", utils.ErrPermanent, resp.Status)
+			}
+			return fmt.Errorf("unexpected status: %s", resp.Status)
+		}
+		return nil
+	})
+}
+
+// rejected tells that server refused the batch itself, sending it again gives the same answer
+func rejected(code int) bool {
+	return code >= 400 && code < 500 && code != http.StatusRequestTimeout && code != http.StatusTooManyRequests
+}
+
+func (a *agent) sendJSONData(ctx context.Context, els []metrics.Element, cs []collector.Collector) {
+	size := a.cfg.BatchSize
+	if size <= 0 {
+		size = len(els)
+	}
+	for i := 0; i < len(els); i += size {
+		end := i + size
+		if end > len(els) {
+			end = len(els)
+		}
+		err := a.sendBatch(ctx, els[i:end])
+		if err != nil {
+			log.Printf("[ERR][SEND] cant send batch: %s", err)
+		}
+		collector.Ack(cs, els[i:end], err == nil)
+	}
+}
+
+func (a *agent) collect(cs []collector.Collector) []metrics.Element {
+	var out []metrics.Element
+	for _, c := range cs {
+		out = append(out, c.Collect()...)
+	}
+	if len(a.cfg.Labels) > 0 {
+		for i := range out {
+			out[i].Labels = a.cfg.Labels
+		}
+	}
+	return out
+}
+
+func (a *agent) worker(ctx context.Context, jobs <-chan []metrics.Element, cs []collector.Collector) {
+	for els := range jobs {
+		a.sendJSONData(ctx, els, cs)
+	}
+}
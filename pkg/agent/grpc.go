@@ -0,0 +1,67 @@
+package agent
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+
+	pb "github.com/JohnRobertFord/go-plant/internal/proto"
+	"github.com/JohnRobertFord/go-plant/internal/sign"
+	"github.com/JohnRobertFord/go-plant/internal/storage/metrics"
+	"github.com/JohnRobertFord/go-plant/internal/subnet"
+	"github.com/JohnRobertFord/go-plant/internal/utils"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// dialGRPC connects lazily to server, with tlsCfg nil the connection is not encrypted
+func (a *agent) dialGRPC(tlsCfg *tls.Config) (*grpc.ClientConn, error) {
+	creds := insecure.NewCredentials()
+	if tlsCfg != nil {
+		creds = credentials.NewTLS(tlsCfg)
+	}
+	conn, err := grpc.NewClient(a.cfg.Address, grpc.WithTransportCredentials(creds))
+	if err != nil {
+		return nil, err
+	}
+	a.grpcClient = pb.NewMetricsClient(conn)
+	return conn, nil
+}
+
+// sendGRPC delivers json batch, as kept in spool, with UpdateMetrics call
+func (a *agent) sendGRPC(ctx context.Context, ret []byte) error {
+	var els []metrics.Element
+	if err := json.Unmarshal(ret, &els); err != nil {
+		return err
+	}
+	req := &pb.UpdateMetricsRequest{Metrics: make([]*pb.Metric, 0, len(els))}
+	for _, el := range els {
+		req.Metrics = append(req.Metrics, pb.FromElement(el))
+	}
+
+	md := metadata.MD{}
+	if a.realIP != "" {
+		md.Set(subnet.Header, a.realIP)
+	}
+	if a.cfg.Key != "" {
+		data, err := pb.Payload(req)
+		if err != nil {
+			return err
+		}
+		md.Set(sign.Header, sign.Sum(a.cfg.Key, data))
+	}
+	ctx = metadata.NewOutgoingContext(ctx, md)
+
+	return utils.Retry(ctx, func() error {
+		_, err := a.grpcClient.UpdateMetrics(ctx, req)
+		if status.Code(err) == codes.InvalidArgument {
+			return fmt.Errorf("%w: %s", utils.ErrPermanent, err)
+		}
+		return err
+	})
+}
@@ -0,0 +1,192 @@
+package agent
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/JohnRobertFord/go-plant/internal/grpcserver"
+	pb "github.com/JohnRobertFord/go-plant/internal/proto"
+	"github.com/JohnRobertFord/go-plant/internal/sign"
+	"github.com/JohnRobertFord/go-plant/internal/storage/metrics"
+	"github.com/JohnRobertFord/go-plant/internal/subnet"
+	"google.golang.org/grpc/metadata"
+)
+
+// maxFrames bounds the retry buffer, the oldest frames are dropped first
+const maxFrames = 10000
+
+// Streamer pushes reports as frames over one long-lived stream. Frames stay
+// in the buffer until server acknowledges them and are resent after reconnect
+type Streamer struct {
+	id     string
+	key    string
+	realIP string
+	client pb.MetricsClient
+
+	mu      sync.Mutex
+	seq     uint64
+	frames  []*pb.MetricFrame
+	notify  chan struct{}
+	drained chan struct{}
+}
+
+func (a *agent) newStreamer() *Streamer {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return &Streamer{
+		id:      hex.EncodeToString(b),
+		key:     a.cfg.Key,
+		realIP:  a.realIP,
+		client:  a.grpcClient,
+		notify:  make(chan struct{}, 1),
+		drained: make(chan struct{}),
+	}
+}
+
+// Push queues json batch, as kept in spool, as the next frame
+func (s *Streamer) Push(ret []byte) error {
+	var els []metrics.Element
+	if err := json.Unmarshal(ret, &els); err != nil {
+		return err
+	}
+	frame := &pb.MetricFrame{Metrics: make([]*pb.Metric, 0, len(els))}
+	for _, el := range els {
+		frame.Metrics = append(frame.Metrics, pb.FromElement(el))
+	}
+
+	s.mu.Lock()
+	s.seq++
+	frame.Seq = s.seq
+	if s.key != "" {
+		data, err := pb.FramePayload(frame)
+		if err != nil {
+			s.mu.Unlock()
+			return err
+		}
+		frame.Hash = sign.Sum(s.key, data)
+	}
+	s.frames = append(s.frames, frame)
+	if len(s.frames) > maxFrames {
+		log.Printf("[ERR][STREAM] buffer is full, frame %d dropped", s.frames[0].GetSeq())
+		s.frames = s.frames[1:]
+	}
+	s.mu.Unlock()
+
+	select {
+	case s.notify <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// trim drops frames acknowledged by server
+func (s *Streamer) trim(committed uint64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	i := 0
+	for i < len(s.frames) && s.frames[i].GetSeq() <= committed {
+		i++
+	}
+	s.frames = s.frames[i:]
+}
+
+// after returns buffered frames with seq greater than sent
+func (s *Streamer) after(sent uint64) []*pb.MetricFrame {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var out []*pb.MetricFrame
+	for _, f := range s.frames {
+		if f.GetSeq() > sent {
+			out = append(out, f)
+		}
+	}
+	return out
+}
+
+func (s *Streamer) pending() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.frames)
+}
+
+// Run keeps the stream open until ctx is done, reconnecting after errors
+func (s *Streamer) Run(ctx context.Context) {
+	defer close(s.drained)
+	for {
+		err := s.session(ctx)
+		if ctx.Err() != nil {
+			return
+		}
+		log.Printf("[ERR][STREAM] stream closed: %v, %d frames pending", err, s.pending())
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(time.Second):
+		}
+	}
+}
+
+func (s *Streamer) session(ctx context.Context) error {
+	md := metadata.Pairs(grpcserver.AgentHeader, s.id)
+	if s.realIP != "" {
+		md.Set(subnet.Header, s.realIP)
+	}
+	streamCtx, cancel := context.WithCancel(metadata.NewOutgoingContext(ctx, md))
+	defer cancel()
+	stream, err := s.client.StreamMetrics(streamCtx)
+	if err != nil {
+		return err
+	}
+
+	acks := make(chan error, 1)
+	go func() {
+		for {
+			ack, err := stream.Recv()
+			if err != nil {
+				acks <- err
+				return
+			}
+			s.trim(ack.GetCommittedSeq())
+		}
+	}()
+
+	var sent uint64
+	for {
+		for _, f := range s.after(sent) {
+			if err := stream.Send(f); err != nil {
+				return <-acks
+			}
+			sent = f.GetSeq()
+		}
+		select {
+		case <-s.notify:
+		case err := <-acks:
+			return err
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// Flush waits until every frame is acknowledged or ctx is done, then closes the stream
+func (s *Streamer) Flush(ctx context.Context, stop context.CancelFunc) {
+	for s.pending() > 0 {
+		select {
+		case <-ctx.Done():
+			log.Printf("[ERR][STREAM] %d frames not acknowledged", s.pending())
+			stop()
+			<-s.drained
+			return
+		case <-time.After(100 * time.Millisecond):
+		}
+	}
+	stop()
+	<-s.drained
+}
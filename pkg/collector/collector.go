@@ -0,0 +1,127 @@
+// Package collector holds metric sources of the agent. Collectors from other modules
+// implement Collector and call Register from init. A team builds its own main that
+// imports them, usually blank, and runs the agent with agent.Run from pkg/agent.
+package collector
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/JohnRobertFord/go-plant/internal/storage/metrics"
+)
+
+// Element is a single metric reported by a collector. It is an alias, so that
+// collectors outside this module can name the type.
+type Element = metrics.Element
+
+// Collector is a source of metrics for the agent. Poll is called every poll
+// interval from its own goroutine, Collect on every report.
+type Collector interface {
+	Poll()
+	Collect() []Element
+}
+
+// Acker is implemented by collectors reporting counters. Collect hands out
+// the deltas accumulated so far; Ack is called with the sent elements once the
+// server has accepted them (ok) or the send has failed, so that unsent deltas
+// are reported again on the next cycle.
+type Acker interface {
+	Ack(sent []Element, ok bool)
+}
+
+// Ack notifies every Acker in cs about the result of sending els.
+func Ack(cs []Collector, els []metrics.Element, ok bool) {
+	for _, c := range cs {
+		if a, is := c.(Acker); is {
+			a.Ack(els, ok)
+		}
+	}
+}
+
+var (
+	mu       sync.Mutex
+	registry = make(map[string]func() Collector)
+	order    []string
+)
+
+// Register makes a collector available by name. It is meant to be called from init.
+func Register(name string, factory func() Collector) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	if _, ok := registry[name]; ok {
+		panic(fmt.Sprintf("collector %q already registered", name))
+	}
+	registry[name] = factory
+	order = append(order, name)
+}
+
+// Names returns all registered collector names in registration order.
+func Names() []string {
+	mu.Lock()
+	defer mu.Unlock()
+
+	return append([]string(nil), order...)
+}
+
+// New builds collectors listed in enabled (all registered if empty) except disabled ones.
+func New(enabled, disabled []string) ([]Collector, error) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	if len(enabled) == 0 {
+		enabled = order
+	}
+	skip := make(map[string]bool)
+	for _, name := range disabled {
+		if _, ok := registry[name]; !ok {
+			return nil, fmt.Errorf("unknown collector %q", name)
+		}
+		skip[name] = true
+	}
+
+	var out []Collector
+	for _, name := range enabled {
+		factory, ok := registry[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown collector %q, available: %v", name, sortedNames())
+		}
+		if skip[name] {
+			continue
+		}
+		out = append(out, factory())
+	}
+	return out, nil
+}
+
+func sortedNames() []string {
+	names := append([]string(nil), order...)
+	sort.Strings(names)
+	return names
+}
+
+func FormatMetric(t string, name string, value uint64) metrics.Element {
+	val := float64(value)
+	return metrics.Element{
+		ID:    name,
+		MType: t,
+		Value: &val,
+	}
+}
+
+func FormatFloatMetric(t string, name string, value float64) metrics.Element {
+	return metrics.Element{
+		ID:    name,
+		MType: t,
+		Value: &value,
+	}
+}
+
+func FormatCounter(t string, name string, value int64) metrics.Element {
+	return metrics.Element{
+		ID:    name,
+		MType: t,
+		Delta: &value,
+	}
+}
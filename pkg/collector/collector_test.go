@@ -0,0 +1,92 @@
+package collector
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNew(t *testing.T) {
+	var tests = []struct {
+		name     string
+		enabled  []string
+		disabled []string
+		want     int
+		wantErr  bool
+	}{
+		{
+			name: "all by default",
+			want: len(Names()),
+		},
+		{
+			name:    "only runtime",
+			enabled: []string{"runtime"},
+			want:    1,
+		},
+		{
+			name:     "all but random",
+			disabled: []string{"random"},
+			want:     len(Names()) - 1,
+		},
+		{
+			name:    "unknown collector",
+			enabled: []string{"unknown"},
+			wantErr: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			cs, err := New(test.enabled, test.disabled)
+			if test.wantErr {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Len(t, cs, test.want)
+		})
+	}
+}
+
+func TestPollCountAck(t *testing.T) {
+	p := &PollCount{}
+	cs := []Collector{p}
+
+	p.Poll()
+	p.Poll()
+	first := p.Collect()
+	require.Equal(t, int64(2), *first[0].Delta)
+
+	// polls during an in-flight send are not part of it
+	p.Poll()
+	Ack(cs, first, false)
+
+	// failed delta is reported again together with the new poll
+	second := p.Collect()
+	require.Equal(t, int64(3), *second[0].Delta)
+	Ack(cs, second, true)
+
+	third := p.Collect()
+	assert.Equal(t, int64(0), *third[0].Delta)
+}
+
+func TestCPUUsage(t *testing.T) {
+	var tests = []struct {
+		name      string
+		prev, cur cpuTimes
+		want      float64
+	}{
+		{name: "half busy", prev: cpuTimes{idle: 100, total: 200}, cur: cpuTimes{idle: 150, total: 300}, want: 50},
+		{name: "idle went back", prev: cpuTimes{idle: 100, total: 200}, cur: cpuTimes{idle: 90, total: 300}, want: 100},
+		{name: "idle grew more than total", prev: cpuTimes{idle: 100, total: 200}, cur: cpuTimes{idle: 250, total: 300}, want: 0},
+		{name: "core replugged", prev: cpuTimes{idle: 1000, total: 2000}, cur: cpuTimes{idle: 10, total: 20}, want: 0},
+		{name: "no time passed", prev: cpuTimes{idle: 100, total: 200}, cur: cpuTimes{idle: 100, total: 200}, want: 0},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			assert.Equal(t, test.want, cpuUsage(test.prev, test.cur))
+		})
+	}
+}
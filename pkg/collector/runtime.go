@@ -0,0 +1,121 @@
+package collector
+
+import (
+	"math/rand"
+	"runtime"
+	"sync"
+
+	"github.com/JohnRobertFord/go-plant/internal/storage/metrics"
+)
+
+func init() {
+	Register("runtime", func() Collector { return &Runtime{memstats: &runtime.MemStats{}} })
+	Register("pollcount", func() Collector { return &PollCount{} })
+	Register("random", func() Collector { return &Random{} })
+}
+
+// Runtime reports Go runtime MemStats of the agent process.
+type Runtime struct {
+	mu       sync.Mutex
+	memstats *runtime.MemStats
+}
+
+func (r *Runtime) Poll() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	runtime.ReadMemStats(r.memstats)
+}
+
+func (r *Runtime) Collect() []metrics.Element {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return []metrics.Element{
+		FormatMetric("gauge", "Alloc", r.memstats.Alloc),
+		FormatMetric("gauge", "BuckHashSys", r.memstats.BuckHashSys),
+		FormatMetric("gauge", "Frees", r.memstats.Frees),
+		FormatMetric("gauge", "GCSys", r.memstats.GCSys),
+		FormatMetric("gauge", "HeapAlloc", r.memstats.HeapAlloc),
+		FormatMetric("gauge", "HeapIdle", r.memstats.HeapIdle),
+		FormatMetric("gauge", "HeapInuse", r.memstats.HeapInuse),
+		FormatMetric("gauge", "HeapObjects", r.memstats.HeapObjects),
+		FormatMetric("gauge", "HeapReleased", r.memstats.HeapReleased),
+		FormatMetric("gauge", "HeapSys", r.memstats.HeapSys),
+		FormatMetric("gauge", "LastGC", r.memstats.LastGC),
+		FormatMetric("gauge", "Lookups", r.memstats.Lookups),
+		FormatMetric("gauge", "MCacheInuse", r.memstats.MCacheInuse),
+		FormatMetric("gauge", "MCacheSys", r.memstats.MCacheSys),
+		FormatMetric("gauge", "MSpanInuse", r.memstats.MSpanInuse),
+		FormatMetric("gauge", "MSpanSys", r.memstats.MSpanSys),
+		FormatMetric("gauge", "Mallocs", r.memstats.Mallocs),
+		FormatMetric("gauge", "NextGC", r.memstats.NextGC),
+		FormatMetric("gauge", "NumForcedGC", uint64(r.memstats.NumForcedGC)),
+		FormatMetric("gauge", "NumGC", uint64(r.memstats.NumGC)),
+		FormatMetric("gauge", "OtherSys", r.memstats.OtherSys),
+		FormatMetric("gauge", "PauseTotalNs", r.memstats.PauseTotalNs),
+		FormatMetric("gauge", "StackInuse", r.memstats.StackInuse),
+		FormatMetric("gauge", "StackSys", r.memstats.StackSys),
+		FormatMetric("gauge", "Sys", r.memstats.Sys),
+		FormatMetric("gauge", "TotalAlloc", r.memstats.TotalAlloc),
+		FormatFloatMetric("gauge", "GCCPUFraction", r.memstats.GCCPUFraction),
+	}
+}
+
+// PollCount counts poll cycles of the agent since the last acknowledged report.
+type PollCount struct {
+	mu      sync.Mutex
+	pending int64
+}
+
+func (p *PollCount) Poll() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.pending++
+}
+
+func (p *PollCount) Collect() []metrics.Element {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	// polls handed out are in flight until acknowledged, so a concurrent
+	// report never sends them a second time; a failed send returns them back
+	delta := p.pending
+	p.pending = 0
+	return []metrics.Element{FormatCounter("counter", "PollCount", delta)}
+}
+
+func (p *PollCount) Ack(sent []metrics.Element, ok bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for _, el := range sent {
+		if el.ID != "PollCount" || el.MType != "counter" || el.Delta == nil {
+			continue
+		}
+		if !ok {
+			p.pending += *el.Delta
+		}
+	}
+}
+
+// Random reports a random value on every poll.
+type Random struct {
+	mu    sync.Mutex
+	value uint64
+}
+
+func (r *Random) Poll() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.value = rand.Uint64()
+}
+
+func (r *Random) Collect() []metrics.Element {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return []metrics.Element{FormatMetric("gauge", "RandomValue", r.value)}
+}
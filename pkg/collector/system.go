@@ -0,0 +1,88 @@
+package collector
+
+import (
+	"fmt"
+	"log"
+	"sync"
+
+	"github.com/JohnRobertFord/go-plant/internal/storage/metrics"
+)
+
+type cpuTimes struct {
+	idle  uint64
+	total uint64
+}
+
+// System reports memory, per-core CPU and root filesystem usage of the host.
+type System struct {
+	mu          sync.Mutex
+	TotalMemory uint64
+	FreeMemory  uint64
+	TotalDisk   uint64
+	FreeDisk    uint64
+	cpu         []float64
+	prev        []cpuTimes
+}
+
+func (s *System) Poll() {
+	total, free, err := readMemInfo()
+	if err != nil {
+		log.Printf("[ERR][SYSTEM] cant read memory info: %s", err)
+	}
+	diskTotal, diskFree, err := readDisk("/")
+	if err != nil {
+		log.Printf("[ERR][SYSTEM] cant read disk info: %s", err)
+	}
+	times, err := readCPUTimes()
+	if err != nil {
+		log.Printf("[ERR][SYSTEM] cant read cpu info: %s", err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.TotalMemory = total
+	s.FreeMemory = free
+	s.TotalDisk = diskTotal
+	s.FreeDisk = diskFree
+
+	if len(s.prev) == len(times) {
+		s.cpu = make([]float64, len(times))
+		for i, t := range times {
+			s.cpu[i] = cpuUsage(s.prev[i], t)
+		}
+	}
+	s.prev = times
+}
+
+// cpuUsage returns busy percent of a core between two reads. Counters are not
+// monotonic (iowait goes back, a core can be unplugged and plugged again),
+// so deltas are signed and the result is clamped to 0..100
+func cpuUsage(prev, cur cpuTimes) float64 {
+	dTotal := int64(cur.total - prev.total)
+	dIdle := int64(cur.idle - prev.idle)
+	if dTotal <= 0 {
+		return 0
+	}
+	return min(max(100*float64(dTotal-dIdle)/float64(dTotal), 0), 100)
+}
+
+func (s *System) Collect() []metrics.Element {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	out := []metrics.Element{
+		FormatMetric("gauge", "TotalMemory", s.TotalMemory),
+		FormatMetric("gauge", "FreeMemory", s.FreeMemory),
+		FormatMetric("gauge", "TotalDisk", s.TotalDisk),
+		FormatMetric("gauge", "FreeDisk", s.FreeDisk),
+	}
+	if s.TotalDisk > 0 {
+		used := 100 * float64(s.TotalDisk-s.FreeDisk) / float64(s.TotalDisk)
+		out = append(out, FormatFloatMetric("gauge", "DiskUtilization", used))
+	}
+	for i, c := range s.cpu {
+		out = append(out, FormatFloatMetric("gauge", fmt.Sprintf("CPUutilization%d", i+1), c))
+	}
+	return out
+}
@@ -0,0 +1,88 @@
+//go:build linux
+
+package collector
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// system is registered only where host stats can be read
+func init() {
+	Register("system", func() Collector { return &System{} })
+}
+
+// readMemInfo returns total and free memory in bytes from /proc/meminfo
+func readMemInfo() (uint64, uint64, error) {
+	f, err := os.Open("/proc/meminfo")
+	if err != nil {
+		return 0, 0, err
+	}
+	defer f.Close()
+
+	var total, free uint64
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 2 {
+			continue
+		}
+		v, err := strconv.ParseUint(fields[1], 10, 64)
+		if err != nil {
+			continue
+		}
+		switch fields[0] {
+		case "MemTotal:":
+			total = v * 1024
+		case "MemFree:":
+			free = v * 1024
+		}
+	}
+	return total, free, scanner.Err()
+}
+
+// readCPUTimes returns idle and total jiffies for every core from /proc/stat
+func readCPUTimes() ([]cpuTimes, error) {
+	f, err := os.Open("/proc/stat")
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var out []cpuTimes
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		// skip aggregated "cpu" line, take only "cpuN"
+		if len(fields) < 5 || !strings.HasPrefix(fields[0], "cpu") || fields[0] == "cpu" {
+			continue
+		}
+		var t cpuTimes
+		for i, field := range fields[1:] {
+			v, err := strconv.ParseUint(field, 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("bad /proc/stat line %q: %w", scanner.Text(), err)
+			}
+			// idle and iowait
+			if i == 3 || i == 4 {
+				t.idle += v
+			}
+			t.total += v
+		}
+		out = append(out, t)
+	}
+	return out, scanner.Err()
+}
+
+// readDisk returns total and available space in bytes for filesystem at path
+func readDisk(path string) (uint64, uint64, error) {
+	var st syscall.Statfs_t
+	if err := syscall.Statfs(path, &st); err != nil {
+		return 0, 0, err
+	}
+	return st.Blocks * uint64(st.Bsize), st.Bavail * uint64(st.Bsize), nil
+}
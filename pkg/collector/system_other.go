@@ -0,0 +1,21 @@
+//go:build !linux
+
+package collector
+
+import "errors"
+
+// system collector is not registered here, these stubs only keep System building
+
+var errUnsupported = errors.New("not supported on this platform")
+
+func readMemInfo() (uint64, uint64, error) {
+	return 0, 0, errUnsupported
+}
+
+func readCPUTimes() ([]cpuTimes, error) {
+	return nil, errUnsupported
+}
+
+func readDisk(string) (uint64, uint64, error) {
+	return 0, 0, errUnsupported
+}